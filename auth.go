@@ -1,20 +1,467 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/whitelist"
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
 )
 
-func basicAuth(h http.HandlerFunc) http.HandlerFunc {
+// apiKey is a caller of the API. Its own rules, when it has some, replace the
+// default ones for the transactions it sends.
+type apiKey struct {
+	ID         string   `yaml:"id"`         // the Basic auth user, or keyId of signed requests
+	Label      string   `yaml:"label"`      // a description, for humans
+	SecretHash string   `yaml:"secretHash"` // bcrypt, argon2id or hex encoded SHA-256 of the Basic auth secret
+	HMACSecret string   `yaml:"hmacSecret"` // shared secret of signed requests
+	Enabled    bool     `yaml:"enabled"`
+	Scopes     []string `yaml:"scopes"`   // allowed scopes, all when left out
+	Accounts   []string `yaml:"accounts"` // signing accounts it may send from, all when left out
+	Rules      string   `yaml:"rules"`    // Lua rules file, optional
+	Policy     string   `yaml:"policy"`   // policy file checked before the rules, optional
+
+	rules whitelist.Rules
+}
+
+// apiKeys are the API keys by ID
+type apiKeys map[string]*apiKey
+
+// envAPIKeys is the single key given by BASIC_AUTH_USER, with
+// BASIC_AUTH_PASS_HASH or BASIC_AUTH_PASS and HMAC_SECRET, using the default
+// rules
+func envAPIKeys(user, secretHash, hmacSecret string) (apiKeys, error) {
+	if secretHash != "" || hmacSecret == "" {
+		err := checkSecretHash(secretHash)
+		if err != nil {
+			return nil, fmt.Errorf("BASIC_AUTH_PASS_HASH: %v", err)
+		}
+	}
+	// The only key owns all the transactions, even the ones recorded before
+	// the keys
+	scopes := []string{scopeSend, scopeRetry, scopeRead, scopeAdmin}
+	return apiKeys{user: &apiKey{ID: user, SecretHash: secretHash, HMACSecret: hmacSecret, Enabled: true, Scopes: scopes}}, nil
+}
+
+// loadAPIKeys reads a YAML or JSON array of keys and prepares their rules.
+// The paths of the rules are relative to the keys file.
+func loadAPIKeys(
+	path string,
+	abis whitelist.ABIRegistry,
+	db whitelist.SpendingReader,
+	timeout time.Duration,
+) (apiKeys, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []*apiKey
+	err = yaml.UnmarshalStrict(b, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	resolve := relativeTo(path)
+	keys := apiKeys{}
+	for _, k := range list {
+		if k.ID == "" {
+			return nil, errors.New("API key without id")
+		}
+		if _, ok := keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate API key %v", k.ID)
+		}
+		if k.SecretHash != "" || k.HMACSecret == "" {
+			if err := checkSecretHash(k.SecretHash); err != nil {
+				return nil, fmt.Errorf("API key %v: %v", k.ID, err)
+			}
+		}
+
+		for _, a := range k.Accounts {
+			if !common.IsHexAddress(a) {
+				return nil, fmt.Errorf("API key %v: invalid account %v", k.ID, a)
+			}
+		}
+
+		k.rules, err = loadRuleFiles(resolve(k.Policy), resolve(k.Rules), abis, db, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("API key %v: %v", k.ID, err)
+		}
+
+		keys[k.ID] = k
+	}
+	return keys, nil
+}
+
+// relativeTo resolves the paths found in a configuration file relative to
+// the file. Empty paths stay empty.
+func relativeTo(path string) func(string) string {
+	dir := filepath.Dir(path)
+	return func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+}
+
+// loadRuleFiles prepares a policy and Lua rules, the policy first. Either
+// path can be empty, and it returns nil rules when both are.
+func loadRuleFiles(
+	policyPath string,
+	rulesPath string,
+	abis whitelist.ABIRegistry,
+	db whitelist.SpendingReader,
+	timeout time.Duration,
+) (whitelist.Rules, error) {
+	var all whitelist.All
+	if policyPath != "" {
+		f, err := os.Open(policyPath)
+		if err != nil {
+			return nil, err
+		}
+		policy, err := whitelist.LoadPolicy(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, policy)
+	}
+	if rulesPath != "" {
+		source, err := ioutil.ReadFile(rulesPath)
+		if err != nil {
+			return nil, err
+		}
+		v, err := whitelist.NewValidator(string(source), abis, db, whitelist.PoolSize, timeout)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, v)
+	}
+	if len(all) == 0 {
+		return nil, nil
+	}
+	return all, nil
+}
+
+// hashSecret hashes a secret with SHA-256, the weakest form of secretHash,
+// for secrets given in clear
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// checkSecretHash tells if a hash is a bcrypt hash, an argon2id hash in the
+// PHC format ($argon2id$v=19$m=65536,t=3,p=4$salt$hash) or a hex encoded SHA-256
+func checkSecretHash(hash string) error {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		_, err := bcrypt.Cost([]byte(hash))
+		return err
+	case strings.HasPrefix(hash, "$argon2id$"):
+		_, err := parseArgon2(hash)
+		return err
+	}
+	if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+		return errors.New("secretHash must be a bcrypt, argon2id or hex encoded SHA-256 hash")
+	}
+	return nil
+}
+
+// argon2Hash is a decoded argon2id hash
+type argon2Hash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// Bounds of the argon2id parameters, so that a hash can't make logins panic
+// or exhaust the server
+const (
+	argon2MaxMemory = 1024 * 1024 // KiB, 1 GiB
+	argon2MaxTime   = 16
+)
+
+func parseArgon2(hash string) (argon2Hash, error) {
+	var a argon2Hash
+	var version int
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return a, errors.New("invalid argon2id hash")
+	}
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return a, errors.New("unsupported argon2id version")
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &a.memory, &a.time, &a.threads)
+	if err != nil {
+		return a, errors.New("invalid argon2id parameters")
+	}
+	if a.memory < 1 || a.memory > argon2MaxMemory || a.time < 1 || a.time > argon2MaxTime || a.threads < 1 {
+		return a, fmt.Errorf("argon2id parameters out of bounds: m must be 1 to %d, t 1 to %d and p at least 1", argon2MaxMemory, argon2MaxTime)
+	}
+	a.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return a, errors.New("invalid argon2id salt")
+	}
+	a.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(a.key) == 0 {
+		return a, errors.New("invalid argon2id key")
+	}
+	return a, nil
+}
+
+// verifySecret compares a secret to its hash in constant time
+func verifySecret(hash, secret string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) == nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		a, err := parseArgon2(hash)
+		if err != nil {
+			return false
+		}
+		key := argon2.IDKey([]byte(secret), a.salt, a.time, a.memory, a.threads, uint32(len(a.key)))
+		return subtle.ConstantTimeCompare(key, a.key) == 1
+	}
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(strings.ToLower(hash))) == 1
+}
+
+// dummySecretHash is a bcrypt hash of a random secret, with the cost of the
+// example of the README. It's checked when there is no key to check, so that
+// the unknown keys take about as long to refuse as the wrong secrets.
+const dummySecretHash = "$2a$12$tKaHhPU3IAdgljr5ms0K8OLbQxDJyfBWwIA7vLYUzbYRpbbiGLCLa"
+
+// authenticate returns the enabled key matching the credentials
+func (keys apiKeys) authenticate(user, pass string) (*apiKey, bool) {
+	k, ok := keys[user]
+	if !ok || !k.Enabled || k.SecretHash == "" {
+		verifySecret(dummySecretHash, pass)
+		return nil, false
+	}
+	if !verifySecret(k.SecretHash, pass) {
+		return nil, false
+	}
+	return k, true
+}
+
+// rulesOf returns the rules of a key, or the default rules when the key has
+// none or doesn't exist anymore
+func (keys apiKeys) rulesOf(id string, rules whitelist.Rules) whitelist.Rules {
+	if k, ok := keys[id]; ok && k.rules != nil {
+		return k.rules
+	}
+	return rules
+}
+
+type callerKey struct{}
+
+// callerOf returns the API key that authenticated the request, nil if the
+// request wasn't authenticated
+func callerOf(r *http.Request) *apiKey {
+	k, _ := r.Context().Value(callerKey{}).(*apiKey)
+	return k
+}
+
+// hasScope tells if the key is allowed a scope. Keys without a list of scopes
+// are allowed all of them.
+func (k *apiKey) hasScope(scope string) bool {
+	if k.Scopes == nil {
+		return true
+	}
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// mayUse tells if the key may send from a signing account. Keys without a
+// list of accounts may use all of them.
+func (k *apiKey) mayUse(address common.Address) bool {
+	if k.Accounts == nil {
+		return true
+	}
+	for _, a := range k.Accounts {
+		if common.HexToAddress(a) == address {
+			return true
+		}
+	}
+	return false
+}
+
+// mayAccess tells if the caller may get, list or retry a transaction: only its
+// own ones, unless its scopes explicitly list tx:admin. Requests without a
+// caller aren't restricted.
+func mayAccess(r *http.Request, t transaction) bool {
+	k := callerOf(r)
+	return k == nil || k.isAdmin() || t.APIKey == k.ID
+}
+
+// isAdmin tells if the key may access the transactions of the other callers.
+// Unlike the other scopes, keys without a list of scopes don't have it.
+func (k *apiKey) isAdmin() bool {
+	return k.Scopes != nil && k.hasScope(scopeAdmin)
+}
+
+// callerRules returns the rules of the caller, or the default rules
+func callerRules(r *http.Request, rules whitelist.Rules) whitelist.Rules {
+	if k := callerOf(r); k != nil && k.rules != nil {
+		return k.rules
+	}
+	return rules
+}
+
+// ruleCaller tells the rules who sent the request
+func ruleCaller(r *http.Request) whitelist.Caller {
+	return whitelist.Caller{ID: callerID(r), ClientCert: clientCertID(r)}
+}
+
+// callerID returns the ID of the caller, empty if unknown
+func callerID(r *http.Request) string {
+	if k := callerOf(r); k != nil {
+		return k.ID
+	}
+	return ""
+}
+
+// authenticator lets through the requests of the enabled API keys, with
+// Basic credentials or signed with HMAC, the requests bearing a token of the
+// OIDC provider, and the requests with only a verified client certificate.
+// With a throttle, sources and users failing too often are locked out for a
+// while.
+type authenticator struct {
+	keys       apiKeys
+	throttle   *authThrottle
+	replays    *replayGuard
+	bearer     *jwtVerifier // nil to refuse bearer tokens
+	certScopes []string     // scopes of the unknown certificates, nil to refuse them
+}
+
+func newAuthenticator(
+	keys apiKeys,
+	throttle *authThrottle,
+	maxSkew time.Duration,
+	bearer *jwtVerifier,
+	certScopes []string,
+) *authenticator {
+	return &authenticator{
+		keys:       keys,
+		throttle:   throttle,
+		replays:    newReplayGuard(maxSkew),
+		bearer:     bearer,
+		certScopes: certScopes,
+	}
+}
+
+// require wraps a handler to only serve authenticated requests allowed the
+// scope. The key of the caller is in the context of the request.
+func (a *authenticator) require(scope string, h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		signed, isSigned := parseSignedAuth(authorization)
+		isBearer := strings.HasPrefix(authorization, "Bearer ")
 		user, pass, _ := r.BasicAuth()
+		if isSigned {
+			user = signed.keyID
+		}
+		ip := a.throttle.clientIP(r)
+
+		if wait := a.throttle.locked(ip, user); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			http.Error(w, "too many failed attempts", http.StatusTooManyRequests)
+			return
+		}
 
-		if user != os.Getenv("BASIC_AUTH_USER") || pass != os.Getenv("BASIC_AUTH_PASS") {
+		var k *apiKey
+		var err error
+		switch {
+		case isSigned:
+			k, err = a.verifySigned(r, signed)
+		case isBearer:
+			k, err = a.verifyBearer(strings.TrimPrefix(authorization, "Bearer "))
+		case authorization == "" && clientCertID(r) != "":
+			k, err = a.certCaller(clientCertID(r))
+		default:
+			var ok bool
+			k, ok = a.keys.authenticate(user, pass)
+			if !ok {
+				err = errors.New("unauthorized")
+			}
+		}
+		if err == errBodyTooLarge {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			a.throttle.fail(ip, user)
 			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
+		a.throttle.succeed(ip, user)
 
-		h.ServeHTTP(w, r)
+		if !k.hasScope(scope) {
+			http.Error(w, "missing scope "+scope, http.StatusForbidden)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, k)))
+	}
+}
+
+// certCaller is the caller of a request authenticated by its client
+// certificate alone. An API key with the identity of the certificate as ID
+// gives its rules and scopes. Other identities only get the certScopes, and
+// are refused without them.
+func (a *authenticator) certCaller(id string) (*apiKey, error) {
+	k, ok := a.keys[id]
+	if !ok {
+		if a.certScopes == nil {
+			return nil, errors.New("unauthorized: unknown certificate " + id)
+		}
+		return &apiKey{ID: id, Enabled: true, Scopes: a.certScopes}, nil
+	}
+	if !k.Enabled {
+		return nil, errors.New("unauthorized")
+	}
+	return k, nil
+}
+
+// verifyBearer checks a token of the OIDC provider. The caller is named after
+// the subject, and gets the rules and accounts of the API key with that ID if
+// any. Tokens of the subject of a disabled key are refused.
+func (a *authenticator) verifyBearer(token string) (*apiKey, error) {
+	if a.bearer == nil {
+		return nil, errors.New("unauthorized")
+	}
+	sub, scopes, err := a.bearer.verify(token)
+	if err != nil {
+		return nil, errors.New("unauthorized: " + err.Error())
+	}
+	k := &apiKey{ID: sub, Enabled: true, Scopes: scopes}
+	if named, ok := a.keys[sub]; ok {
+		if !named.Enabled {
+			return nil, errors.New("unauthorized: disabled key " + sub)
+		}
+		k.Accounts, k.rules = named.Accounts, named.rules
 	}
+	return k, nil
 }
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+	"github.com/WeTrustPlatform/secure-signing-serv/whitelist"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeTestFile writes a file in the directory of a test and returns its path
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// loadTestAPIKeys writes then loads a keys file with mobile, which has its own
+// rules, backend, and the disabled old
+func loadTestAPIKeys(t *testing.T, dir string) apiKeys {
+	writeTestFile(t, dir, "small.lua", `function validate(tx) return tx.valueNumber <= 100, "too much" end`)
+	path := writeTestFile(t, dir, "keys.yaml", `
+- id: mobile
+  label: Mobile app
+  secretHash: "`+hashSecret("mobile-secret")+`"
+  enabled: true
+  rules: small.lua
+- id: backend
+  secretHash: "`+hashSecret("backend-secret")+`"
+  enabled: true
+- id: old
+  secretHash: "`+hashSecret("old-secret")+`"
+  enabled: false
+`)
+
+	keys, err := loadAPIKeys(path, nil, nil, whitelist.DefaultTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func Test_apiKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	keys := loadTestAPIKeys(t, dir)
+
+	t.Run("Authenticates the enabled keys", func(t *testing.T) {
+		for _, c := range []struct {
+			user, pass string
+			want       int
+		}{
+			{"mobile", "mobile-secret", 200},
+			{"backend", "backend-secret", 200},
+			{"mobile", "backend-secret", 401},
+			{"old", "old-secret", 401},
+			{"nobody", "", 401},
+		} {
+			var got string
+			h := newAuthenticator(keys, nil, time.Minute, nil, nil).require(scopeSend, func(w http.ResponseWriter, r *http.Request) {
+				got = callerID(r)
+			})
+			req, _ := http.NewRequest("GET", "/v1/proxy/transactions", nil)
+			req.SetBasicAuth(c.user, c.pass)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != c.want {
+				t.Errorf("%v: response code = %v, want %v", c.user, rr.Code, c.want)
+			}
+			if c.want == 200 && got != c.user {
+				t.Errorf("%v: caller = %v, want %v", c.user, got, c.user)
+			}
+		}
+	})
+
+	t.Run("Checks a hash even without a key to check", func(t *testing.T) {
+		if cost, err := bcrypt.Cost([]byte(dummySecretHash)); err != nil || cost != 12 {
+			t.Errorf("dummy hash cost = %v, error = %v, want %v", cost, err, 12)
+		}
+		// A bcrypt hash of cost 12 takes way more than a millisecond
+		for _, user := range []string{"nobody", "old"} {
+			start := time.Now()
+			if _, ok := keys.authenticate(user, "secret"); ok {
+				t.Errorf("%v: authenticated, want refused", user)
+			}
+			if elapsed := time.Since(start); elapsed < time.Millisecond {
+				t.Errorf("%v: refused in %v, want a hash check", user, elapsed)
+			}
+		}
+	})
+
+	t.Run("Refuses invalid keys files", func(t *testing.T) {
+		for _, content := range []string{
+			`[{"id": "a", "secretHash": "abc", "enabled": true}]`,
+			`[{"id": "a", "secretHash": "` + hashSecret("a") + `", "enabled": true, "rules": "missing.lua"}]`,
+			`[{"id": "a", "secretHash": "` + hashSecret("a") + `"}, {"id": "a", "secretHash": "` + hashSecret("a") + `"}]`,
+			`[{"id": "a", "secret": "a"}]`,
+			`[{"id": "a", "secretHash": "` + hashSecret("a") + `", "accounts": ["0x123"]}]`,
+		} {
+			_, err := loadAPIKeys(writeTestFile(t, dir, "bad.yaml", content), nil, nil, whitelist.DefaultTimeout)
+			if err == nil {
+				t.Errorf("%v: error = %v, want an error", content, err)
+			}
+		}
+	})
+}
+
+func Test_apiKeyRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	keys := loadTestAPIKeys(t, dir)
+
+	t.Run("Applies the rules of the key and records it", func(t *testing.T) {
+		ctx := context.Background()
+		ownerKey, _ := crypto.GenerateKey()
+		owner := bind.NewKeyedTransactor(ownerKey)
+		client := backends.NewSimulatedBackend(core.GenesisAlloc{
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+		}, 4000000)
+		db := &dbMock{}
+		accounts, err := newKeyring(ctx, client, db, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		h := newAuthenticator(keys, nil, time.Minute, nil, nil).require(scopeSend, txHandler(client, types.HomesteadSigner{}, nil, newTestValidator(t, `function validate(tx) return true end`), accounts, db))
+		send := func(user, pass, value string) int {
+			p := sss.TxPayload{To: owner.From.Hex(), Value: value, GasPrice: "1000000000"}
+			b := new(bytes.Buffer)
+			json.NewEncoder(b).Encode(p)
+			req, _ := http.NewRequest("POST", "/v1/proxy/transactions", b)
+			req.SetBasicAuth(user, pass)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			client.Commit()
+			return rr.Code
+		}
+
+		if code := send("mobile", "mobile-secret", "1000"); code != 403 {
+			t.Errorf("response code = %v, want %v", code, 403)
+		}
+		if code := send("backend", "backend-secret", "1000"); code != 200 {
+			t.Errorf("response code = %v, want %v", code, 200)
+		}
+		if code := send("mobile", "mobile-secret", "10"); code != 200 {
+			t.Errorf("response code = %v, want %v", code, 200)
+		}
+
+		if len(db.txs) != 2 || db.txs[0].APIKey != "backend" || db.txs[1].APIKey != "mobile" {
+			t.Errorf("recorded transactions = %+v", db.txs)
+		}
+	})
+}
+
+func Test_secretHashes(t *testing.T) {
+	t.Run("Verifies bcrypt, argon2id and SHA-256 hashes", func(t *testing.T) {
+		bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		salt := []byte("0123456789abcdef")
+		key := argon2.IDKey([]byte("secret"), salt, 1, 1024, 1, 32)
+		argon2Hash := fmt.Sprintf("$argon2id$v=%d$m=1024,t=1,p=1$%v$%v",
+			argon2.Version,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key))
+
+		for _, hash := range []string{string(bcryptHash), argon2Hash, hashSecret("secret")} {
+			if err := checkSecretHash(hash); err != nil {
+				t.Errorf("%v: error = %v", hash, err)
+			}
+			if !verifySecret(hash, "secret") {
+				t.Errorf("%v: secret refused", hash)
+			}
+			if verifySecret(hash, "Secret") {
+				t.Errorf("%v: wrong secret accepted", hash)
+			}
+		}
+
+		for _, hash := range []string{
+			"", "secret", "$2a$invalid", "$argon2id$v=19$m=1024$c2FsdA$a2V5",
+			"$argon2id$v=19$m=1024,t=1,p=0$c2FsdA$a2V5",
+			"$argon2id$v=19$m=1024,t=0,p=1$c2FsdA$a2V5",
+			"$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5",
+			"$argon2id$v=19$m=4294967295,t=1,p=1$c2FsdA$a2V5",
+			"$argon2id$v=19$m=1024,t=4294967295,p=1$c2FsdA$a2V5",
+		} {
+			if err := checkSecretHash(hash); err == nil {
+				t.Errorf("%v: error = %v, want an error", hash, err)
+			}
+			if verifySecret(hash, "secret") {
+				t.Errorf("%v: secret accepted", hash)
+			}
+		}
+	})
+}
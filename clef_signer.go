@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// defaultClefTimeout is how long Clef has to answer, approval included
+const defaultClefTimeout = time.Minute
+
+// clefSigner has the transactions signed by an external signer speaking the
+// JSON-RPC API of Clef
+type clefSigner struct {
+	address common.Address
+	client  *rpc.Client
+	timeout time.Duration // gives up on the approvals that don't come
+}
+
+// clefTxArgs is the transaction account_signTransaction signs
+type clefTxArgs struct {
+	From                 common.Address  `json:"from"`
+	To                   *common.Address `json:"to"`
+	Gas                  hexutil.Uint64  `json:"gas"`
+	GasPrice             *hexutil.Big    `json:"gasPrice,omitempty"`
+	MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas,omitempty"`
+	Value                hexutil.Big     `json:"value"`
+	Nonce                hexutil.Uint64  `json:"nonce"`
+	Data                 hexutil.Bytes   `json:"data"`
+	ChainID              *hexutil.Big    `json:"chainId,omitempty"`
+}
+
+// clefSignedTx is the response of account_signTransaction
+type clefSignedTx struct {
+	Raw hexutil.Bytes `json:"raw"`
+}
+
+func newClefSigner(endpoint string, address common.Address, timeout time.Duration) (*clefSigner, error) {
+	client, err := rpc.DialHTTP(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &clefSigner{address: address, client: client, timeout: timeout}, nil
+}
+
+func (s *clefSigner) SignTx(ctx context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	args := clefTxArgs{
+		From:  s.address,
+		To:    tx.To(),
+		Gas:   hexutil.Uint64(tx.Gas()),
+		Value: hexutil.Big(*tx.Value()),
+		Nonce: hexutil.Uint64(tx.Nonce()),
+		Data:  tx.Data(),
+	}
+	if tx.Type() == types.DynamicFeeTxType {
+		args.MaxFeePerGas = (*hexutil.Big)(tx.GasFeeCap())
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(tx.GasTipCap())
+	} else {
+		args.GasPrice = (*hexutil.Big)(tx.GasPrice())
+	}
+	if chainID != nil {
+		args.ChainID = (*hexutil.Big)(chainID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	var res clefSignedTx
+	err := s.client.CallContext(ctx, &res, "account_signTransaction", args)
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("clef didn't answer within %v", s.timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signed := new(types.Transaction)
+	err = signed.UnmarshalBinary(res.Raw)
+	if err != nil {
+		return nil, err
+	}
+	err = checkSigned(tx, signed, chainID, s.address)
+	if err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+func (s *clefSigner) Address() common.Address {
+	return s.address
+}
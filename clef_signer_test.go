@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// clefStandIn answers account_signTransaction like Clef, with a key of its own
+type clefStandIn struct {
+	key    *ecdsa.PrivateKey
+	tamper bool          // signs another nonce than the requested one
+	hang   chan struct{} // doesn't answer until closed, like a pending approval
+}
+
+func (c *clefStandIn) SignTransaction(args clefTxArgs) (*clefSignedTx, error) {
+	if c.hang != nil {
+		<-c.hang
+	}
+	nonce := uint64(args.Nonce)
+	if c.tamper {
+		nonce++
+	}
+	var tx *types.Transaction
+	if args.MaxFeePerGas != nil {
+		tx = types.NewTx(&types.DynamicFeeTx{
+			ChainID:   (*big.Int)(args.ChainID),
+			Nonce:     nonce,
+			GasTipCap: (*big.Int)(args.MaxPriorityFeePerGas),
+			GasFeeCap: (*big.Int)(args.MaxFeePerGas),
+			Gas:       uint64(args.Gas),
+			To:        args.To,
+			Value:     (*big.Int)(&args.Value),
+			Data:      args.Data,
+		})
+	} else {
+		tx = types.NewTx(&types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: (*big.Int)(args.GasPrice),
+			Gas:      uint64(args.Gas),
+			To:       args.To,
+			Value:    (*big.Int)(&args.Value),
+			Data:     args.Data,
+		})
+	}
+	signed, err := types.SignTx(tx, types.LatestSignerForChainID((*big.Int)(args.ChainID)), c.key)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := signed.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return &clefSignedTx{Raw: raw}, nil
+}
+
+func newClefStandIn(t *testing.T, c *clefStandIn) *httptest.Server {
+	server := rpc.NewServer()
+	err := server.RegisterName("account", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(server)
+}
+
+func Test_clefSigner(t *testing.T) {
+	ctx := context.Background()
+
+	ownerKey, _ := crypto.GenerateKey()
+	owner := crypto.PubkeyToAddress(ownerKey.PublicKey)
+	otherKey, _ := crypto.GenerateKey()
+	to := common.HexToAddress("0x5597285BbE81BaF351e2C0884e9a5f4416958862")
+	chainID := big.NewInt(1337)
+
+	t.Run("Sends transactions signed by Clef", func(t *testing.T) {
+		standIn := newClefStandIn(t, &clefStandIn{key: ownerKey})
+		defer standIn.Close()
+
+		client := backends.NewSimulatedBackend(core.GenesisAlloc{
+			owner: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+		}, 4000000)
+		db := &dbMock{}
+		clef, err := newClefSigner(standIn.URL, owner, defaultClefTimeout)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		a, err := newAccount(ctx, client, db, clef, "clef", nil)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		signer := types.NewLondonSigner(chainID)
+		h := txHandler(client, signer, nil, newTestValidator(t, `function validate(tx) return true end`), keyring{a}, db)
+
+		for _, p := range []sss.TxPayload{
+			{To: to.Hex(), Value: "1", GasPrice: "1000000000"},
+			{To: to.Hex(), Value: "1", MaxFeePerGas: "2000000000", MaxPriorityFeePerGas: "1000000000"},
+		} {
+			b := new(bytes.Buffer)
+			json.NewEncoder(b).Encode(p)
+			req, _ := http.NewRequest("POST", "/v1/proxy/transactions", b)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != 200 {
+				t.Fatalf("response code = %v, want %v: %v", rr.Code, 200, rr.Body.String())
+				return
+			}
+			tx, _, err := client.TransactionByHash(ctx, common.HexToHash(rr.Body.String()))
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			if sender, _ := types.Sender(signer, tx); sender != owner {
+				t.Errorf("sender = %v, want %v", sender.Hex(), owner.Hex())
+			}
+		}
+	})
+
+	t.Run("Refuses what Clef didn't sign as asked", func(t *testing.T) {
+		tx := types.NewTransaction(0, to, big.NewInt(1), 21000, big.NewInt(1000000000), nil)
+		for name, c := range map[string]*clefStandIn{
+			"another key":   {key: otherKey},
+			"another nonce": {key: ownerKey, tamper: true},
+		} {
+			standIn := newClefStandIn(t, c)
+			clef, err := newClefSigner(standIn.URL, owner, defaultClefTimeout)
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			if _, err := clef.SignTx(ctx, tx, chainID); err == nil {
+				t.Errorf("%v: error = %v, want an error", name, err)
+			}
+			standIn.Close()
+		}
+
+		clef, err := newClefSigner("http://127.0.0.1:1", owner, defaultClefTimeout)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if _, err := clef.SignTx(ctx, tx, chainID); err == nil {
+			t.Errorf("error = %v, want an error for an unreachable signer", err)
+		}
+	})
+
+	t.Run("Gives up on an approval that doesn't come", func(t *testing.T) {
+		hang := make(chan struct{})
+		standIn := newClefStandIn(t, &clefStandIn{key: ownerKey, hang: hang})
+		defer standIn.Close()
+		defer close(hang)
+
+		client := backends.NewSimulatedBackend(core.GenesisAlloc{
+			owner: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+		}, 4000000)
+		db := &dbMock{}
+		clef, err := newClefSigner(standIn.URL, owner, 100*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		a, err := newAccount(ctx, client, db, clef, "clef", nil)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		h := txHandler(client, types.NewLondonSigner(chainID), nil, newTestValidator(t, `function validate(tx) return true end`), keyring{a}, db)
+
+		b := new(bytes.Buffer)
+		json.NewEncoder(b).Encode(sss.TxPayload{To: to.Hex(), Value: "1", GasPrice: "1000000000"})
+		req, _ := http.NewRequest("POST", "/v1/proxy/transactions", b)
+		rr := httptest.NewRecorder()
+		start := time.Now()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != 500 || time.Since(start) > 5*time.Second {
+			t.Errorf("response code = %v after %v, want %v", rr.Code, time.Since(start), 500)
+		}
+		if got := a.nonces.Peek(); got != 0 {
+			t.Errorf("next nonce = %v, want %v", got, 0)
+		}
+	})
+}
@@ -5,17 +5,23 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/big"
+	"net/http"
 
 	"github.com/WeTrustPlatform/secure-signing-serv/sss"
 	"github.com/ethereum/go-ethereum/common"
 )
 
 func main() {
-	var endpoint, to, value, gasPrice, data string
+	var endpoint, keyID, secret, from, to, value, gasPrice, maxFee, maxPriorityFee, data string
 	flag.StringVar(&endpoint, "endpoint", "", "The 3S API endpoint")
+	flag.StringVar(&keyID, "keyid", "", "The API key signing the request, optional")
+	flag.StringVar(&secret, "secret", "", "The HMAC secret of the API key, optional")
+	flag.StringVar(&from, "from", "", "The server account sending the transaction, optional")
 	flag.StringVar(&to, "to", "", "The receiver address")
 	flag.StringVar(&value, "value", "0", "The amount to be transferred")
-	flag.StringVar(&gasPrice, "gasprice", "0", "The price of the gas")
+	flag.StringVar(&gasPrice, "gasprice", "", "The price of the gas, sends a legacy transaction")
+	flag.StringVar(&maxFee, "maxfee", "", "The EIP-1559 max fee per gas, optional")
+	flag.StringVar(&maxPriorityFee, "maxpriorityfee", "", "The EIP-1559 max priority fee per gas, optional")
 	flag.StringVar(&data, "data", "", "Data field of the transaction")
 	flag.Parse()
 
@@ -24,10 +30,27 @@ func main() {
 		fmt.Println("Couldn't parse value")
 		return
 	}
-	gp, ok := big.NewInt(0).SetString(gasPrice, 10)
-	if !ok {
-		fmt.Println("Couldn't parse gasPrice")
-		return
+	var gp, mf, mpf *big.Int
+	if gasPrice != "" {
+		gp, ok = big.NewInt(0).SetString(gasPrice, 10)
+		if !ok {
+			fmt.Println("Couldn't parse gasPrice")
+			return
+		}
+	}
+	if maxFee != "" {
+		mf, ok = big.NewInt(0).SetString(maxFee, 10)
+		if !ok {
+			fmt.Println("Couldn't parse maxFee")
+			return
+		}
+	}
+	if maxPriorityFee != "" {
+		mpf, ok = big.NewInt(0).SetString(maxPriorityFee, 10)
+		if !ok {
+			fmt.Println("Couldn't parse maxPriorityFee")
+			return
+		}
 	}
 
 	var toAddrPtr *common.Address
@@ -37,12 +60,17 @@ func main() {
 	}
 
 	c := sss.NewClient(endpoint)
-	resp, err := c.Transact(
-		toAddrPtr,
-		v,
-		gp,
-		data,
-	)
+	if secret != "" {
+		c = sss.NewSigningClient(endpoint, keyID, secret)
+	}
+	c.From = from
+	var resp *http.Response
+	var err error
+	if gp != nil {
+		resp, err = c.Transact(toAddrPtr, v, gp, data)
+	} else {
+		resp, err = c.TransactDynamicFee(toAddrPtr, v, mf, mpf, data)
+	}
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/whitelist"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// fixtureSpending answers the spending queries of the rules with the
+// spending of the sample being tested
+type fixtureSpending struct {
+	sample *whitelist.Sample
+}
+
+func (f *fixtureSpending) Spending(filter whitelist.SpendingFilter) (whitelist.Spending, error) {
+	if f.sample == nil || f.sample.Spending == nil {
+		return whitelist.Spending{}, errors.New("the rules call spending and the sample doesn't set it")
+	}
+	value, err := whitelist.ParseValue(f.sample.Spending.Value)
+	if err != nil {
+		return whitelist.Spending{}, fmt.Errorf("spending: %v", err)
+	}
+	return whitelist.Spending{Value: value, Gas: f.sample.Spending.Gas, Count: f.sample.Spending.Count}, nil
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "test" {
+		fmt.Println("Usage: 3s-rules test -rules rules.lua -fixture samples.yaml")
+		os.Exit(2)
+	}
+
+	var rulesPath, fixturePath, abisPath, policyPath, from, chainID string
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	flags.StringVar(&rulesPath, "rules", "", "The Lua rules file")
+	flags.StringVar(&fixturePath, "fixture", "", "The YAML or JSON transactions with their expected outcome")
+	flags.StringVar(&abisPath, "abis", "", "The contract ABIs decoded for the rules, optional")
+	flags.StringVar(&policyPath, "policy", "", "The policy checked before the rules, optional")
+	flags.StringVar(&from, "from", "0x0000000000000000000000000000000000000000", "The address signing the transactions")
+	flags.StringVar(&chainID, "chainid", "1", "The chain ID of the transactions")
+	flags.Parse(os.Args[2:])
+
+	if rulesPath == "" && policyPath == "" {
+		fmt.Println("-rules or -policy is required")
+		os.Exit(2)
+	}
+	if fixturePath == "" {
+		fmt.Println("-fixture is required")
+		os.Exit(2)
+	}
+
+	id, ok := big.NewInt(0).SetString(chainID, 10)
+	if !ok {
+		fmt.Println("Couldn't parse chainid")
+		os.Exit(2)
+	}
+
+	spending := &fixtureSpending{}
+	rules, err := loadRules(rulesPath, abisPath, policyPath, spending)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	f, err := os.Open(fixturePath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	samples, err := whitelist.LoadSamples(f)
+	f.Close()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	failed := 0
+	for i, s := range samples {
+		name := s.Name
+		if name == "" {
+			name = fmt.Sprint(i)
+		}
+		spending.sample = &samples[i]
+		err := s.Check(rules, common.HexToAddress(from), id)
+		if err != nil {
+			failed++
+			fmt.Println("FAIL", name+":", err)
+			continue
+		}
+		fmt.Println("ok  ", name)
+	}
+
+	fmt.Printf("%v passed, %v failed\n", len(samples)-failed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// loadRules builds the rules the same way as the server: the policy first,
+// then the Lua rules
+func loadRules(rulesPath, abisPath, policyPath string, spending whitelist.SpendingReader) (whitelist.Rules, error) {
+	var all whitelist.All
+
+	if policyPath != "" {
+		f, err := os.Open(policyPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		policy, err := whitelist.LoadPolicy(f)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, policy)
+	}
+
+	if rulesPath != "" {
+		abis := whitelist.ABIRegistry{}
+		if abisPath != "" {
+			f, err := os.Open(abisPath)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			abis, err = whitelist.LoadABIRegistry(f)
+			if err != nil {
+				return nil, err
+			}
+		}
+		source, err := ioutil.ReadFile(rulesPath)
+		if err != nil {
+			return nil, err
+		}
+		v, err := whitelist.NewValidator(string(source), abis, spending, 0, whitelist.DefaultTimeout)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, v)
+	}
+
+	return all, nil
+}
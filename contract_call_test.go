@@ -32,13 +32,20 @@ func Test_contractCall(t *testing.T) {
 
 	t.Run("Can call a method of a smart contract", func(t *testing.T) {
 		client := backends.NewSimulatedBackend(core.GenesisAlloc{
-			owner.From: core.GenesisAccount{Balance: big.NewInt(50000000000)},
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
 		}, 4000000)
+		db := &dbMock{}
+		accounts, err := newKeyring(ctx, client, db, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
 		message := "hohay"
 
 		byteCode := helloworld.HelloWorldBin[2:]
 
-		p := sss.TxPayload{GasPrice: "1", Data: byteCode}
+		p := sss.TxPayload{GasPrice: "1000000000", Data: byteCode}
 		b := new(bytes.Buffer)
 		json.NewEncoder(b).Encode(p)
 		req, err := http.NewRequest("POST", "/tx", b)
@@ -47,7 +54,7 @@ func Test_contractCall(t *testing.T) {
 			return
 		}
 
-		h := txHandler(client, signer, rules, owner.From, ownerKey, &dbMock{})
+		h := txHandler(client, signer, nil, newTestValidator(t, rules), accounts, db)
 		deployRR := httptest.NewRecorder()
 		h.ServeHTTP(deployRR, req)
 		client.Commit()
@@ -79,7 +86,7 @@ func Test_contractCall(t *testing.T) {
 			return
 		}
 
-		p2 := sss.TxPayload{To: deployReceipt.ContractAddress.Hex(), GasPrice: "1", Data: common.Bytes2Hex(data)}
+		p2 := sss.TxPayload{To: deployReceipt.ContractAddress.Hex(), GasPrice: "1000000000", Data: common.Bytes2Hex(data)}
 		b2 := new(bytes.Buffer)
 		json.NewEncoder(b2).Encode(p2)
 		callReq, err := http.NewRequest("POST", "/tx", b2)
@@ -88,7 +95,7 @@ func Test_contractCall(t *testing.T) {
 			return
 		}
 
-		txh := txHandler(client, signer, rules, owner.From, ownerKey, &dbMock{})
+		txh := txHandler(client, signer, nil, newTestValidator(t, rules), accounts, db)
 		callRR := httptest.NewRecorder()
 		txh.ServeHTTP(callRR, callReq)
 		client.Commit()
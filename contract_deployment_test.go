@@ -30,12 +30,18 @@ func Test_contractDeployment(t *testing.T) {
 
 	t.Run("Can deploy a contract", func(t *testing.T) {
 		client := backends.NewSimulatedBackend(core.GenesisAlloc{
-			owner.From: core.GenesisAccount{Balance: big.NewInt(50000000000)},
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
 		}, 4000000)
+		db := &dbMock{}
+		accounts, err := newKeyring(ctx, client, db, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
 
 		byteCode := helloworld.HelloWorldBin[2:]
 
-		p := sss.TxPayload{GasPrice: "1", Data: byteCode}
+		p := sss.TxPayload{GasPrice: "1000000000", Data: byteCode}
 		b := new(bytes.Buffer)
 		json.NewEncoder(b).Encode(p)
 		req, err := http.NewRequest("POST", "/tx", b)
@@ -44,7 +50,7 @@ func Test_contractDeployment(t *testing.T) {
 			return
 		}
 
-		h := txHandler(client, signer, rules, owner.From, ownerKey, &dbMock{})
+		h := txHandler(client, signer, nil, newTestValidator(t, rules), accounts, db)
 		rr := httptest.NewRecorder()
 		h.ServeHTTP(rr, req)
 		client.Commit()
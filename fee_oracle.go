@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/whitelist"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// feeOracle picks the gas price of a legacy transaction when the caller
+// doesn't set one
+type feeOracle interface {
+	GasPrice(ctx context.Context) (*big.Int, error)
+}
+
+// nodeOracle uses the gas price suggested by the node
+type nodeOracle struct {
+	client Client
+}
+
+func (o nodeOracle) GasPrice(ctx context.Context) (*big.Int, error) {
+	return o.client.SuggestGasPrice(ctx)
+}
+
+// fixedOracle always uses the same gas price
+type fixedOracle struct {
+	price *big.Int
+}
+
+func (o fixedOracle) GasPrice(ctx context.Context) (*big.Int, error) {
+	return new(big.Int).Set(o.price), nil
+}
+
+// percentileOracle uses a percentile of the gas prices paid in the latest
+// blocks. The result is cached until a new block is mined.
+type percentileOracle struct {
+	client     Client
+	blocks     int
+	percentile int
+
+	mu    sync.Mutex
+	head  *big.Int
+	price *big.Int
+}
+
+func newPercentileOracle(client Client, blocks, percentile int) (*percentileOracle, error) {
+	if blocks < 1 {
+		return nil, errors.New("the percentile oracle needs at least 1 block")
+	}
+	if percentile < 0 || percentile > 100 {
+		return nil, errors.New("the percentile must be between 0 and 100")
+	}
+	return &percentileOracle{
+		client:     client,
+		blocks:     blocks,
+		percentile: percentile,
+	}, nil
+}
+
+func (o *percentileOracle) GasPrice(ctx context.Context) (*big.Int, error) {
+	head, err := o.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.head != nil && o.head.Cmp(head.Number) == 0 {
+		return new(big.Int).Set(o.price), nil
+	}
+
+	var prices []*big.Int
+	number := new(big.Int).Set(head.Number)
+	for i := 0; i < o.blocks && number.Sign() >= 0; i++ {
+		block, err := o.client.BlockByNumber(ctx, number)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range block.Transactions() {
+			prices = append(prices, paidGasPrice(tx, block.BaseFee()))
+		}
+		number.Sub(number, big.NewInt(1))
+	}
+
+	// Nothing to learn from empty blocks
+	if len(prices) == 0 {
+		return o.client.SuggestGasPrice(ctx)
+	}
+
+	sort.Slice(prices, func(i, j int) bool { return prices[i].Cmp(prices[j]) < 0 })
+	o.head = head.Number
+	o.price = prices[(len(prices)-1)*o.percentile/100]
+	return new(big.Int).Set(o.price), nil
+}
+
+// paidGasPrice is the price per gas a mined transaction paid
+func paidGasPrice(tx *types.Transaction, baseFee *big.Int) *big.Int {
+	if tx.Type() == types.LegacyTxType || baseFee == nil {
+		return tx.GasPrice()
+	}
+	return effectiveGasPrice(whitelist.Fees{GasFeeCap: tx.GasFeeCap(), GasTipCap: tx.GasTipCap()}, baseFee)
+}
+
+// cappedOracle keeps the price of another oracle within bounds. A nil bound
+// doesn't limit.
+type cappedOracle struct {
+	oracle feeOracle
+	min    *big.Int
+	max    *big.Int
+}
+
+func newCappedOracle(oracle feeOracle, min, max *big.Int) (cappedOracle, error) {
+	if min != nil && max != nil && min.Cmp(max) > 0 {
+		return cappedOracle{}, errors.New("the minimum gas price is above the maximum")
+	}
+	return cappedOracle{
+		oracle: oracle,
+		min:    min,
+		max:    max,
+	}, nil
+}
+
+func (o cappedOracle) GasPrice(ctx context.Context) (*big.Int, error) {
+	price, err := o.oracle.GasPrice(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if o.min != nil && price.Cmp(o.min) < 0 {
+		price = new(big.Int).Set(o.min)
+	}
+	if o.max != nil && price.Cmp(o.max) > 0 {
+		price = new(big.Int).Set(o.max)
+	}
+	return price, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func Test_cappedOracle(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Keeps the price within the caps", func(t *testing.T) {
+		for _, c := range []struct {
+			price int64
+			want  int64
+		}{{5, 10}, {15, 15}, {25, 20}} {
+			o, err := newCappedOracle(fixedOracle{big.NewInt(c.price)}, big.NewInt(10), big.NewInt(20))
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			got, err := o.GasPrice(ctx)
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			if got.Cmp(big.NewInt(c.want)) != 0 {
+				t.Errorf("gas price = %v, want %v", got, c.want)
+			}
+		}
+	})
+
+	t.Run("Refuses a minimum above the maximum", func(t *testing.T) {
+		_, err := newCappedOracle(fixedOracle{big.NewInt(15)}, big.NewInt(20), big.NewInt(10))
+		if err == nil {
+			t.Errorf("newCappedOracle error = %v, want an error", err)
+		}
+	})
+}
+
+func Test_feeOracle(t *testing.T) {
+	ctx := context.Background()
+
+	ownerKey, _ := crypto.GenerateKey()
+	owner := bind.NewKeyedTransactor(ownerKey)
+
+	testerKey, _ := crypto.GenerateKey()
+	tester := bind.NewKeyedTransactor(testerKey)
+
+	rules := `function validate(tx) return true end`
+	signer := types.HomesteadSigner{}
+
+	newClient := func() *backends.SimulatedBackend {
+		return backends.NewSimulatedBackend(core.GenesisAlloc{
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+		}, 4000000)
+	}
+
+	t.Run("Uses a percentile of the recent gas prices", func(t *testing.T) {
+		client := newClient()
+		for i, gwei := range []int64{4, 1, 3, 2} {
+			tx := types.NewTransaction(uint64(i), tester.From, big.NewInt(1), 21000, big.NewInt(gwei*1000000000), nil)
+			signedTx, err := types.SignTx(tx, signer, ownerKey)
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			err = client.SendTransaction(ctx, signedTx)
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			if i%2 == 1 {
+				client.Commit()
+			}
+		}
+
+		o, err := newPercentileOracle(client, 2, 50)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		got, err := o.GasPrice(ctx)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if want := big.NewInt(2000000000); got.Cmp(want) != 0 {
+			t.Errorf("gas price = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Falls back to the node on empty blocks", func(t *testing.T) {
+		client := newClient()
+		client.Commit()
+
+		o, err := newPercentileOracle(client, 5, 50)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		got, err := o.GasPrice(ctx)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		want, _ := client.SuggestGasPrice(ctx)
+		if got.Cmp(want) != 0 {
+			t.Errorf("gas price = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Prices transactions sent without fees", func(t *testing.T) {
+		client := newClient()
+		db := &dbMock{}
+		accounts, err := newKeyring(ctx, client, db, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		p := sss.TxPayload{To: tester.From.Hex(), Value: "10000000000"}
+		b := new(bytes.Buffer)
+		json.NewEncoder(b).Encode(p)
+		req, err := http.NewRequest("POST", "/tx", b)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		oracle := cappedOracle{oracle: fixedOracle{big.NewInt(3000000000)}, max: big.NewInt(2500000000)}
+		h := txHandler(client, signer, oracle, newTestValidator(t, rules), accounts, db)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		client.Commit()
+
+		if rr.Code != 200 {
+			t.Errorf("response code = %v, want %v", rr.Code, 200)
+			return
+		}
+		if got := rr.Header().Get("X-Gas-Price"); got != "2500000000" {
+			t.Errorf("X-Gas-Price = %v, want %v", got, "2500000000")
+		}
+
+		tx, _, err := client.TransactionByHash(ctx, common.HexToHash(rr.Body.String()))
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if tx.Type() != types.LegacyTxType || tx.GasPrice().Cmp(big.NewInt(2500000000)) != 0 {
+			t.Errorf("tx type = %v, gas price = %v", tx.Type(), tx.GasPrice())
+		}
+	})
+}
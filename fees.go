@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/whitelist"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// recordedFees reads the fees stored with a transaction
+func recordedFees(t transaction) whitelist.Fees {
+	var f whitelist.Fees
+	if t.Type != types.DynamicFeeTxType {
+		f.GasPrice, _ = big.NewInt(0).SetString(t.GasPrice, 10)
+		return f
+	}
+	f.GasFeeCap, _ = big.NewInt(0).SetString(t.MaxFeePerGas, 10)
+	f.GasTipCap, _ = big.NewInt(0).SetString(t.MaxPriorityFeePerGas, 10)
+	return f
+}
+
+var errNoBaseFee = errors.New("maxFeePerGas and maxPriorityFeePerGas need a chain that supports dynamic fees")
+
+// fillFees completes the fees the caller omitted. Without any fee the oracle
+// prices a legacy transaction; when no oracle is configured, a dynamic fee
+// transaction is sent, or a legacy one priced by the node on chains that
+// don't support dynamic fees.
+func fillFees(ctx context.Context, client Client, oracle feeOracle, f *whitelist.Fees) error {
+	if !f.Dynamic() {
+		return nil
+	}
+	if f.GasFeeCap != nil || f.GasTipCap != nil {
+		return fillDynamicFees(ctx, client, f)
+	}
+	if oracle == nil {
+		err := fillDynamicFees(ctx, client, f)
+		if err != errNoBaseFee {
+			return err
+		}
+		oracle = nodeOracle{client}
+	}
+
+	price, err := oracle.GasPrice(ctx)
+	if err != nil {
+		return err
+	}
+	*f = whitelist.Fees{GasPrice: price}
+	return nil
+}
+
+// fillDynamicFees completes the fee caps the caller omitted. The tip comes
+// from the node and the fee cap leaves room for the base fee to double.
+func fillDynamicFees(ctx context.Context, client Client, f *whitelist.Fees) error {
+	head, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if head.BaseFee == nil {
+		return errNoBaseFee
+	}
+
+	if f.GasTipCap == nil {
+		f.GasTipCap, err = client.SuggestGasTipCap(ctx)
+		if err != nil {
+			return err
+		}
+		if f.GasFeeCap != nil && f.GasTipCap.Cmp(f.GasFeeCap) > 0 {
+			f.GasTipCap = new(big.Int).Set(f.GasFeeCap)
+		}
+	}
+	if f.GasFeeCap == nil {
+		f.GasFeeCap = new(big.Int).Mul(head.BaseFee, big.NewInt(2))
+		f.GasFeeCap.Add(f.GasFeeCap, f.GasTipCap)
+	}
+	return nil
+}
+
+// bigString formats an optional amount
+func bigString(i *big.Int) string {
+	if i == nil {
+		return ""
+	}
+	return i.String()
+}
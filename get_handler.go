@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func getHandler(db Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hash, ok := hashFromPath(r.URL.Path)
+		if !ok {
+			http.Error(w, "invalid transaction hash", http.StatusBadRequest)
+			return
+		}
+
+		tx, found, err := db.TransactionByHash(hash)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Hash":  hash,
+				"error": err.Error(),
+			}).Error("Error reading transaction.")
+			http.Error(w, "error reading transaction: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Don't tell the other callers the transaction exists
+		if !found || !mayAccess(r, tx) {
+			http.Error(w, "transaction not found", http.StatusNotFound)
+			return
+		}
+
+		previousHashes, err := db.PreviousHashes(tx.ID)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Hash":  hash,
+				"error": err.Error(),
+			}).Error("Error reading previous hashes.")
+			http.Error(w, "error reading transaction: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(transactionJSON(tx, previousHashes))
+	}
+}
+
+// hashFromPath extracts and normalizes the transaction hash of a
+// /v1/proxy/transactions/{hash} path
+func hashFromPath(path string) (string, bool) {
+	b, err := hexutil.Decode(strings.TrimPrefix(path, "/v1/proxy/transactions/"))
+	if err != nil || len(b) != common.HashLength {
+		return "", false
+	}
+	return common.BytesToHash(b).String(), true
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// withCaller authenticates a request as a key
+func withCaller(r *http.Request, k *apiKey) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), callerKey{}, k))
+}
+
+func Test_get(t *testing.T) {
+	ctx := context.Background()
+
+	ownerKey, _ := crypto.GenerateKey()
+	owner := bind.NewKeyedTransactor(ownerKey)
+
+	testerKey, _ := crypto.GenerateKey()
+	tester := bind.NewKeyedTransactor(testerKey)
+
+	rules := `function validate(tx) return true end`
+	signer := types.HomesteadSigner{}
+
+	t.Run("Can follow a replaced transaction", func(t *testing.T) {
+		client := backends.NewSimulatedBackend(core.GenesisAlloc{
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+		}, 4000000)
+		db := &dbMock{}
+		accounts, err := newKeyring(ctx, client, db, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		p := sss.TxPayload{To: tester.From.Hex(), Value: "10000000000", GasPrice: "1000000000"}
+		b := new(bytes.Buffer)
+		json.NewEncoder(b).Encode(p)
+		req, err := http.NewRequest("POST", "/v1/proxy/transactions", b)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		rr := httptest.NewRecorder()
+		txHandler(client, signer, nil, newTestValidator(t, rules), accounts, db).ServeHTTP(rr, req)
+		client.Rollback()
+		hash := rr.Body.String()
+
+		rp := sss.RetryPayload{
+			sss.PatchOperation{Op: "replace", Path: "/gasPrice", Value: "2000000000"},
+		}
+		rb := new(bytes.Buffer)
+		json.NewEncoder(rb).Encode(rp)
+		rreq, err := http.NewRequest("PATCH", "/v1/proxy/transactions/"+hash, rb)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		rrr := httptest.NewRecorder()
+		retryHandler(client, signer, newTestValidator(t, rules), nil, accounts, db).ServeHTTP(rrr, rreq)
+		client.Commit()
+		newHash := rrr.Body.String()
+
+		greq, err := http.NewRequest("GET", "/v1/proxy/transactions/"+hash, nil)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		grr := httptest.NewRecorder()
+		getHandler(db).ServeHTTP(grr, greq)
+
+		if grr.Code != 200 {
+			t.Errorf("response code = %v, want %v", grr.Code, 200)
+			return
+		}
+
+		var got sss.Transaction
+		err = json.NewDecoder(grr.Body).Decode(&got)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		if got.Hash != newHash {
+			t.Errorf("hash = %v, want %v", got.Hash, newHash)
+		}
+		if want := []string{hash}; !reflect.DeepEqual(got.PreviousHashes, want) {
+			t.Errorf("previous hashes = %v, want %v", got.PreviousHashes, want)
+		}
+		if got.Nonce != 0 || got.To != tester.From.Hex() || got.Value != "10000000000" || got.Gas != 21000 || got.GasPrice != "2000000000" {
+			t.Errorf("transaction = %+v", got)
+		}
+		if got.Status != txStatusPending {
+			t.Errorf("status = %v, want %v", got.Status, txStatusPending)
+		}
+	})
+
+}
+
+func Test_getErrors(t *testing.T) {
+	t.Run("Returns 404 for an unknown transaction", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/v1/proxy/transactions/"+common.Hash{}.String(), nil)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		rr := httptest.NewRecorder()
+		getHandler(&dbMock{}).ServeHTTP(rr, req)
+
+		if rr.Code != 404 {
+			t.Errorf("response code = %v, want %v", rr.Code, 404)
+		}
+	})
+
+	t.Run("Returns 400 for an invalid hash", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/v1/proxy/transactions/0x1234", nil)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		rr := httptest.NewRecorder()
+		getHandler(&dbMock{}).ServeHTTP(rr, req)
+
+		if rr.Code != 400 {
+			t.Errorf("response code = %v, want %v", rr.Code, 400)
+		}
+	})
+
+	t.Run("Hides the transactions of the other keys", func(t *testing.T) {
+		db := &dbMock{}
+		hash := common.HexToHash("0x01").String()
+		db.Create(&transaction{Hash: hash, APIKey: "alice", Status: txStatusMined})
+
+		for _, c := range []struct {
+			key  *apiKey
+			want int
+		}{
+			{&apiKey{ID: "alice"}, 200},
+			{&apiKey{ID: "bob"}, 404},
+			{&apiKey{ID: "bob", Scopes: []string{scopeRead}}, 404},
+			{&apiKey{ID: "root", Scopes: []string{scopeRead, scopeAdmin}}, 200},
+		} {
+			req, err := http.NewRequest("GET", "/v1/proxy/transactions/"+hash, nil)
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+
+			rr := httptest.NewRecorder()
+			getHandler(db).ServeHTTP(rr, withCaller(req, c.key))
+
+			if rr.Code != c.want {
+				t.Errorf("%v %v: response code = %v, want %v", c.key.ID, c.key.Scopes, rr.Code, c.want)
+			}
+		}
+	})
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// Scopes required by the routes
+const (
+	scopeSend  = "tx:send"  // send and validate transactions
+	scopeRetry = "tx:retry" // replace transactions
+	scopeRead  = "tx:read"  // get and list transactions
+	scopeAdmin = "tx:admin" // get, list and retry the transactions of the other callers
+)
+
+// jwtVerifier checks the bearer tokens of an OIDC provider against its JSON
+// Web Key Set, read from a file or fetched from a URL
+type jwtVerifier struct {
+	issuer     string // checked when set
+	audience   string // checked when set
+	scopeClaim string // claim holding the scopes, a space separated string or an array
+
+	source  string // file or URL of the key set
+	refresh time.Duration
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey // by kid
+	fetched time.Time
+}
+
+// jwtMethods are the signing algorithms accepted. HMAC and none are left out
+// on purpose, the key set only holds public keys.
+var jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+func newJWTVerifier(source, issuer, audience, scopeClaim string) (*jwtVerifier, error) {
+	v := &jwtVerifier{
+		issuer:     issuer,
+		audience:   audience,
+		scopeClaim: scopeClaim,
+		source:     source,
+		refresh:    time.Minute,
+	}
+	err := v.load()
+	return v, err
+}
+
+// load reads the key set
+func (v *jwtVerifier) load() error {
+	var b []byte
+	var err error
+	if strings.HasPrefix(v.source, "https://") || strings.HasPrefix(v.source, "http://") {
+		client := http.Client{Timeout: 10 * time.Second}
+		var resp *http.Response
+		resp, err = client.Get(v.source)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("error fetching the key set: %v", resp.Status)
+		}
+		b, err = ioutil.ReadAll(resp.Body)
+	} else {
+		b, err = ioutil.ReadFile(v.source)
+	}
+	if err != nil {
+		return err
+	}
+
+	keys, err := parseJWKS(b)
+	if err != nil {
+		return err
+	}
+	v.keys = keys
+	v.fetched = time.Now()
+	return nil
+}
+
+// key returns the public key of a kid. A URL key set is fetched again when a
+// kid is unknown, at most once per refresh period, to follow key rotations.
+func (v *jwtVerifier) key(kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if k, ok := v.lookup(kid); ok {
+		return k, nil
+	}
+	if strings.Contains(v.source, "://") && time.Since(v.fetched) > v.refresh {
+		err := v.load()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"JWKS":  v.source,
+				"error": err.Error(),
+			}).Error("Error fetching the key set.")
+		}
+		if k, ok := v.lookup(kid); ok {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key %v", kid)
+}
+
+// lookup finds a key by kid. Tokens without kid are accepted when the set has
+// a single key.
+func (v *jwtVerifier) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(v.keys) == 1 {
+		for _, k := range v.keys {
+			return k, true
+		}
+	}
+	k, ok := v.keys[kid]
+	return k, ok
+}
+
+// verify checks a token and returns its subject and scopes
+func (v *jwtVerifier) verify(token string) (string, []string, error) {
+	parser := jwt.NewParser(jwt.WithValidMethods(jwtMethods))
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.key(kid)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	if _, ok := claims["exp"]; !ok {
+		return "", nil, errors.New("token without expiry")
+	}
+	if v.issuer != "" && !claims.VerifyIssuer(v.issuer, true) {
+		return "", nil, errors.New("wrong issuer")
+	}
+	if v.audience != "" && !claims.VerifyAudience(v.audience, true) {
+		return "", nil, errors.New("wrong audience")
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return "", nil, errors.New("token without subject")
+	}
+
+	scopes := []string{}
+	switch s := claims[v.scopeClaim].(type) {
+	case string:
+		scopes = strings.Fields(s)
+	case []interface{}:
+		for _, scope := range s {
+			if scope, ok := scope.(string); ok {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return sub, scopes, nil
+}
+
+// jwk is a public key of a JSON Web Key Set
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS reads the RSA and EC signing keys of a key set
+func parseJWKS(b []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err := json.Unmarshal(b, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err := base64URLInt(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("key %v: %v", k.Kid, err)
+			}
+			e, err := base64URLInt(k.E)
+			if err != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("key %v: invalid exponent", k.Kid)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				return nil, fmt.Errorf("key %v: unsupported curve %v", k.Kid, k.Crv)
+			}
+			x, err := base64URLInt(k.X)
+			if err != nil {
+				return nil, fmt.Errorf("key %v: %v", k.Kid, err)
+			}
+			y, err := base64URLInt(k.Y)
+			if err != nil {
+				return nil, fmt.Errorf("key %v: %v", k.Kid, err)
+			}
+			if !curve.IsOnCurve(x, y) {
+				return nil, fmt.Errorf("key %v: point not on the curve", k.Kid)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing key in the key set")
+	}
+	return keys, nil
+}
+
+func base64URLInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/whitelist"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// jwtFixture is an identity provider signing the tokens of the tests, its
+// key set written to a file
+type jwtFixture struct {
+	t        *testing.T
+	rsaKey   *rsa.PrivateKey
+	ecKey    *ecdsa.PrivateKey
+	otherKey *rsa.PrivateKey
+	jwks     []byte
+	path     string
+}
+
+func newJWTFixture(t *testing.T, dir string) *jwtFixture {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N), "e": b64(big.NewInt(int64(rsaKey.E)))},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X), "y": b64(ecKey.Y)},
+	}})
+	path := filepath.Join(dir, "jwks.json")
+	err = ioutil.WriteFile(path, jwks, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &jwtFixture{
+		t:        t,
+		rsaKey:   rsaKey,
+		ecKey:    ecKey,
+		otherKey: otherKey,
+		jwks:     jwks,
+		path:     path,
+	}
+}
+
+func (f *jwtFixture) sign(method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	s, err := token.SignedString(key)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return s
+}
+
+// b64 encodes a number of a JWK
+func b64(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+// jwtClaims are the claims of a valid token of billing with the scope
+func jwtClaims(scope string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   "https://idp.example.com",
+		"aud":   "3s",
+		"sub":   "billing",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": scope,
+	}
+}
+
+// callWithToken calls a route requiring the scope with the token, and returns
+// the response code and the caller the route saw
+func callWithToken(a *authenticator, scope, token string) (int, *apiKey) {
+	var caller *apiKey
+	h := a.require(scope, func(w http.ResponseWriter, r *http.Request) {
+		caller = callerOf(r)
+	})
+	req, _ := http.NewRequest("GET", "/v1/proxy/transactions", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr.Code, caller
+}
+
+func Test_jwtAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	f := newJWTFixture(t, dir)
+	sign, claims, call := f.sign, jwtClaims, callWithToken
+
+	verifier, err := newJWTVerifier(f.path, "https://idp.example.com", "3s", "scope")
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	t.Run("Requires the scope of the route", func(t *testing.T) {
+		a := newAuthenticator(apiKeys{}, nil, time.Minute, verifier, nil)
+		token := sign(jwt.SigningMethodRS256, "rsa", f.rsaKey, claims("tx:read tx:send"))
+
+		code, caller := call(a, scopeSend, token)
+		if code != 200 || caller == nil || caller.ID != "billing" {
+			t.Errorf("response code = %v, caller = %+v", code, caller)
+		}
+		if code, _ := call(a, scopeRetry, token); code != 403 {
+			t.Errorf("response code = %v, want %v", code, 403)
+		}
+	})
+
+	t.Run("Accepts EC keys and scope arrays", func(t *testing.T) {
+		v, err := newJWTVerifier(f.path, "https://idp.example.com", "3s", "scp")
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		a := newAuthenticator(apiKeys{}, nil, time.Minute, v, nil)
+		c := claims("")
+		delete(c, "scope")
+		c["scp"] = []string{"tx:retry"}
+
+		if code, _ := call(a, scopeRetry, sign(jwt.SigningMethodES256, "ec", f.ecKey, c)); code != 200 {
+			t.Errorf("response code = %v, want %v", code, 200)
+		}
+	})
+
+	t.Run("Refuses invalid tokens", func(t *testing.T) {
+		a := newAuthenticator(apiKeys{}, nil, time.Minute, verifier, nil)
+		expired := claims(scopeSend)
+		expired["exp"] = time.Now().Add(-time.Minute).Unix()
+		noExpiry := claims(scopeSend)
+		delete(noExpiry, "exp")
+		wrongIssuer := claims(scopeSend)
+		wrongIssuer["iss"] = "https://evil.example.com"
+		wrongAudience := claims(scopeSend)
+		wrongAudience["aud"] = "other"
+
+		for name, token := range map[string]string{
+			"expired":        sign(jwt.SigningMethodRS256, "rsa", f.rsaKey, expired),
+			"no expiry":      sign(jwt.SigningMethodRS256, "rsa", f.rsaKey, noExpiry),
+			"wrong issuer":   sign(jwt.SigningMethodRS256, "rsa", f.rsaKey, wrongIssuer),
+			"wrong audience": sign(jwt.SigningMethodRS256, "rsa", f.rsaKey, wrongAudience),
+			"unknown key":    sign(jwt.SigningMethodRS256, "rsa", f.otherKey, claims(scopeSend)),
+			"unknown kid":    sign(jwt.SigningMethodRS256, "other", f.otherKey, claims(scopeSend)),
+			"HMAC":           sign(jwt.SigningMethodHS256, "rsa", []byte("secret"), claims(scopeSend)),
+			"garbage":        "not.a.token",
+		} {
+			if code, _ := call(a, scopeSend, token); code != 401 {
+				t.Errorf("%v: response code = %v, want %v", name, code, 401)
+			}
+		}
+
+		if code, _ := call(newAuthenticator(apiKeys{}, nil, time.Minute, nil, nil), scopeSend, sign(jwt.SigningMethodRS256, "rsa", f.rsaKey, claims(scopeSend))); code != 401 {
+			t.Errorf("without key set: response code = %v, want %v", code, 401)
+		}
+	})
+
+	t.Run("Fetches the key set again for an unknown kid", func(t *testing.T) {
+		served := []byte(`{"keys":[]}`)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(served)
+		}))
+		defer server.Close()
+
+		if _, err := newJWTVerifier(server.URL, "", "", "scope"); err == nil {
+			t.Errorf("error = %v, want an error for an empty key set", err)
+		}
+
+		served = []byte(`{"keys":[{"kty":"EC","kid":"ec","crv":"P-256","x":"` + b64(f.ecKey.X) + `","y":"` + b64(f.ecKey.Y) + `"}]}`)
+		v, err := newJWTVerifier(server.URL, "", "", "scope")
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		v.refresh = 0
+		a := newAuthenticator(apiKeys{}, nil, time.Minute, v, nil)
+
+		served = f.jwks
+		if code, _ := call(a, scopeSend, sign(jwt.SigningMethodRS256, "rsa", f.rsaKey, claims(scopeSend))); code != 200 {
+			t.Errorf("response code = %v, want %v", code, 200)
+		}
+	})
+
+}
+
+func Test_jwtSubject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	f := newJWTFixture(t, dir)
+	sign, claims, call := f.sign, jwtClaims, callWithToken
+
+	verifier, err := newJWTVerifier(f.path, "https://idp.example.com", "3s", "scope")
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	t.Run("Applies the rules of the API key named after the subject", func(t *testing.T) {
+		refuse, err := whitelist.NewValidator(`function validate(tx) return false end`, nil, nil, 1, whitelist.DefaultTimeout)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		a := newAuthenticator(apiKeys{"billing": &apiKey{ID: "billing", Enabled: true, rules: refuse}}, nil, time.Minute, verifier, nil)
+
+		_, caller := call(a, scopeSend, sign(jwt.SigningMethodRS256, "rsa", f.rsaKey, claims(scopeSend)))
+		if caller == nil || caller.rules == nil {
+			t.Fatalf("caller = %+v, want the rules of the key", caller)
+			return
+		}
+		tx := types.NewTransaction(0, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil)
+		if d, _ := caller.rules.Validate(tx, common.Address{}, nil, whitelist.Caller{}); d.Allowed {
+			t.Errorf("validate = %v, want %v", d.Allowed, false)
+		}
+	})
+
+	t.Run("Refuses the subject of a disabled key", func(t *testing.T) {
+		a := newAuthenticator(apiKeys{"billing": &apiKey{ID: "billing"}}, nil, time.Minute, verifier, nil)
+
+		code, caller := call(a, scopeSend, sign(jwt.SigningMethodRS256, "rsa", f.rsaKey, claims(scopeSend)))
+		if code != 401 || caller != nil {
+			t.Errorf("response code = %v, caller = %+v, want %v", code, caller, 401)
+		}
+	})
+}
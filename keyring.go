@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+	"github.com/WeTrustPlatform/secure-signing-serv/whitelist"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"gopkg.in/yaml.v2"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// account is a signing account. It hands out its own nonces, and its own
+// rules, when it has some, are checked before those of the caller.
+type account struct {
+	address common.Address
+	signer  Signer
+	label   string
+	pool    bool // shares the requests without from with the other pool accounts
+	rules   whitelist.Rules
+	nonces  *nonceManager
+}
+
+func newAccount(
+	ctx context.Context,
+	client Client,
+	db Recorder,
+	signer Signer,
+	label string,
+	rules whitelist.Rules,
+) (*account, error) {
+	address := signer.Address()
+	nonces, err := newNonceManager(ctx, client, db, address)
+	if err != nil {
+		return nil, err
+	}
+	return &account{
+		address: address,
+		signer:  signer,
+		label:   label,
+		rules:   rules,
+		nonces:  nonces,
+	}, nil
+}
+
+// scope returns the rules the transactions of the account must pass: its own
+// rules, then the given ones
+func (a *account) scope(rules whitelist.Rules) whitelist.Rules {
+	if a.rules == nil {
+		return rules
+	}
+	return whitelist.All{a.rules, rules}
+}
+
+// fillGaps sends 0-value transfers to the account itself with the nonces
+// released below the next one, so that the transactions above them don't
+// wait in the queue of the node until requests reuse them. The transfers
+// aren't checked by the rules nor recorded.
+func (a *account) fillGaps(ctx context.Context, client Client, signer types.Signer) {
+	for {
+		nonce, ok := a.nonces.AcquireReleased()
+		if !ok {
+			return
+		}
+		hash, err := a.fill(ctx, client, signer, nonce)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"From":  a.address.Hex(),
+				"Nonce": nonce,
+				"error": err.Error(),
+			}).Error("Error filling a nonce gap.")
+			if err := a.nonces.Failed(ctx, nonce, err); err != nil {
+				log.WithFields(log.Fields{
+					"Nonce": nonce,
+					"error": err.Error(),
+				}).Error("Error syncing nonce.")
+			}
+			return
+		}
+		log.WithFields(log.Fields{
+			"From":  a.address.Hex(),
+			"Nonce": nonce,
+			"Hash":  hash.Hex(),
+		}).Info("Filled a nonce gap")
+	}
+}
+
+// fill sends a 0-value transfer to the account itself with the nonce
+func (a *account) fill(ctx context.Context, client Client, signer types.Signer, nonce uint64) (common.Hash, error) {
+	var fees whitelist.Fees
+	if signer.ChainID() == nil {
+		price, err := client.SuggestGasPrice(ctx)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		fees.GasPrice = price
+	} else if err := fillFees(ctx, client, nil, &fees); err != nil {
+		return common.Hash{}, err
+	}
+
+	tx := whitelist.NewTransaction(signer.ChainID(), nonce, &a.address, big.NewInt(0), 21000, fees, nil)
+	signedTx, err := a.signer.SignTx(ctx, tx, signer.ChainID())
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return signedTx.Hash(), client.SendTransaction(ctx, signedTx)
+}
+
+// keyring holds the signing accounts. The first one is the default sender.
+type keyring []*account
+
+// newKeyring makes a keyring of local accounts without rules of their own,
+// the first key being the default sender
+func newKeyring(ctx context.Context, client Client, db Recorder, keys ...*ecdsa.PrivateKey) (keyring, error) {
+	var k keyring
+	for _, key := range keys {
+		a, err := newAccount(ctx, client, db, newLocalSigner(key), "", nil)
+		if err != nil {
+			return nil, err
+		}
+		k = append(k, a)
+	}
+	return k, nil
+}
+
+// keyringEntry is an account of KEYRING_FILE. Its key is either in a
+// keystore file, in Clef or in Vault.
+type keyringEntry struct {
+	Keystore       string `yaml:"keystore"`       // encrypted key file
+	PassphraseFile string `yaml:"passphraseFile"` // file holding the passphrase of the key, PASSPHRASE when left out
+	Address        string `yaml:"address"`        // address of the account, for the remote signers
+	Clef           string `yaml:"clef"`           // HTTP endpoint of a Clef external signer
+	Vault          string `yaml:"vault"`          // transit-style secrets engine, like https://vault:8200/v1/transit
+	VaultKey       string `yaml:"vaultKey"`       // name of the key in the engine
+	VaultTokenFile string `yaml:"vaultTokenFile"` // file holding the Vault token, VAULT_TOKEN when left out
+	Label          string `yaml:"label"`          // a description, for humans
+	Pool           bool   `yaml:"pool"`           // in the sender pool
+	Rules          string `yaml:"rules"`          // Lua rules file, optional
+	Policy         string `yaml:"policy"`         // policy file checked before the rules, optional
+}
+
+// loadKeyring reads a YAML or JSON array of accounts, decrypts their keys or
+// connects to their signers, and prepares their rules. The paths are relative
+// to the keyring file. Keys without a passphrase file are decrypted with
+// passphrase, and Vault is called with vaultToken without a token file.
+func loadKeyring(
+	ctx context.Context,
+	path string,
+	passphrase string,
+	vaultToken string,
+	clefTimeout time.Duration,
+	client Client,
+	db Recorder,
+	abis whitelist.ABIRegistry,
+	timeout time.Duration,
+) (keyring, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []keyringEntry
+	err = yaml.UnmarshalStrict(b, &entries)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no account in the keyring")
+	}
+
+	resolve := relativeTo(path)
+	var k keyring
+	for _, e := range entries {
+		signer, err := e.signer(resolve, passphrase, vaultToken, clefTimeout)
+		if err != nil {
+			return nil, err
+		}
+		address := signer.Address()
+		if _, ok := k.get(address); ok {
+			return nil, fmt.Errorf("duplicate account %v", address.Hex())
+		}
+
+		rules, err := loadRuleFiles(resolve(e.Policy), resolve(e.Rules), abis, db, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("account %v: %v", address.Hex(), err)
+		}
+		a, err := newAccount(ctx, client, db, signer, e.Label, rules)
+		if err != nil {
+			return nil, err
+		}
+		a.pool = e.Pool
+		k = append(k, a)
+	}
+	return k, nil
+}
+
+// signer returns the signer of the account, a local one for a keystore file
+func (e keyringEntry) signer(resolve func(string) string, passphrase, vaultToken string, clefTimeout time.Duration) (Signer, error) {
+	switch {
+	case e.Keystore != "" && e.Clef == "" && e.Vault == "":
+		keyJSON, err := ioutil.ReadFile(resolve(e.Keystore))
+		if err != nil {
+			return nil, err
+		}
+		if e.PassphraseFile != "" {
+			passphrase, err = readSecret(resolve(e.PassphraseFile))
+			if err != nil {
+				return nil, err
+			}
+		}
+		key, err := keystore.DecryptKey(keyJSON, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("account %v: %v", e.Keystore, err)
+		}
+		return newLocalSigner(key.PrivateKey), nil
+
+	case e.Keystore == "" && (e.Clef == "") != (e.Vault == ""):
+		if !common.IsHexAddress(e.Address) {
+			return nil, fmt.Errorf("invalid address %q of a remote account", e.Address)
+		}
+		address := common.HexToAddress(e.Address)
+		if e.Clef != "" {
+			return newClefSigner(e.Clef, address, clefTimeout)
+		}
+		if e.VaultKey == "" {
+			return nil, fmt.Errorf("account %v: vaultKey not set", e.Address)
+		}
+		if e.VaultTokenFile != "" {
+			var err error
+			vaultToken, err = readSecret(resolve(e.VaultTokenFile))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return newVaultSigner(e.Vault, e.VaultKey, vaultToken, address), nil
+
+	default:
+		return nil, errors.New("account without exactly one of keystore, clef and vault")
+	}
+}
+
+// readSecret returns the content of a file, without the trailing newline
+func readSecret(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// get returns the account of an address
+func (k keyring) get(address common.Address) (*account, bool) {
+	for _, a := range k {
+		if a.address == address {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+// sender returns the account that signed a recorded transaction
+func (k keyring) sender(t transaction) (*account, error) {
+	a, ok := k.get(common.HexToAddress(t.From))
+	if !ok {
+		return nil, fmt.Errorf("unknown sender %v", t.From)
+	}
+	return a, nil
+}
+
+// pick returns the account a request sends from: the one given as from if
+// the caller may use it, or else the least busy account of the pool the
+// caller may use, or else the first account the caller may use. On error it
+// returns the matching HTTP status code.
+func (k keyring) pick(ctx context.Context, client Client, r *http.Request, p sss.TxPayload) (*account, int, error) {
+	caller := callerOf(r)
+	if p.From != "" {
+		if !common.IsHexAddress(p.From) {
+			return nil, http.StatusBadRequest, errors.New("invalid from address")
+		}
+		a, ok := k.get(common.HexToAddress(p.From))
+		if !ok {
+			return nil, http.StatusBadRequest, errors.New("unknown account " + p.From)
+		}
+		if caller != nil && !caller.mayUse(a.address) {
+			return nil, http.StatusForbidden, errors.New("account not allowed " + p.From)
+		}
+		return a, http.StatusOK, nil
+	}
+
+	var allowed, pool []*account
+	for _, a := range k {
+		if caller != nil && !caller.mayUse(a.address) {
+			continue
+		}
+		allowed = append(allowed, a)
+		if a.pool {
+			pool = append(pool, a)
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, http.StatusForbidden, errors.New("no account allowed")
+	}
+	if len(pool) == 0 {
+		return allowed[0], http.StatusOK, nil
+	}
+
+	// An invalid value is refused later on
+	value, ok := big.NewInt(0).SetString(p.Value, 10)
+	if !ok {
+		value = big.NewInt(0)
+	}
+	a, err := leastBusy(ctx, client, pool, value)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err.Error(),
+		}).Error("Error reading the state of the pool.")
+		return nil, http.StatusInternalServerError, errors.New("error picking an account: " + err.Error())
+	}
+	return a, http.StatusOK, nil
+}
+
+// leastBusy returns the account with the fewest transactions waiting to be
+// mined, the richest one on a tie. The accounts whose balance is below value
+// come last.
+func leastBusy(ctx context.Context, client Client, accounts []*account, value *big.Int) (*account, error) {
+	var best *account
+	var bestPending uint64
+	var bestBalance *big.Int
+	for _, a := range accounts {
+		mined, err := client.NonceAt(ctx, a.address, nil)
+		if err != nil {
+			return nil, err
+		}
+		balance, err := client.BalanceAt(ctx, a.address, nil)
+		if err != nil {
+			return nil, err
+		}
+		pending := a.nonces.Pending(mined)
+
+		funded, bestFunded := balance.Cmp(value) >= 0, best != nil && bestBalance.Cmp(value) >= 0
+		switch {
+		case best == nil,
+			funded && !bestFunded,
+			funded == bestFunded && pending < bestPending,
+			funded == bestFunded && pending == bestPending && balance.Cmp(bestBalance) > 0:
+			best, bestPending, bestBalance = a, pending, balance
+		}
+	}
+	return best, nil
+}
+
+// checkAPIKeys makes sure the accounts the API keys may use are in the
+// keyring
+func (k keyring) checkAPIKeys(keys apiKeys) error {
+	for _, key := range keys {
+		for _, address := range key.Accounts {
+			if _, ok := k.get(common.HexToAddress(address)); !ok {
+				return fmt.Errorf("API key %v: unknown account %v", key.ID, address)
+			}
+		}
+	}
+	return nil
+}
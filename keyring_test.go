@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+	"github.com/WeTrustPlatform/secure-signing-serv/whitelist"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// sendFrom posts a transaction of value to to from the account, as user with
+// the password "secret" if given
+func sendFrom(h http.HandlerFunc, to common.Address, from, value, user string) *httptest.ResponseRecorder {
+	p := sss.TxPayload{From: from, To: to.Hex(), Value: value, GasPrice: "1000000000"}
+	b := new(bytes.Buffer)
+	json.NewEncoder(b).Encode(p)
+	req, _ := http.NewRequest("POST", "/v1/proxy/transactions", b)
+	if user != "" {
+		req.SetBasicAuth(user, "secret")
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func Test_keyring(t *testing.T) {
+	ctx := context.Background()
+
+	hotKey, _ := crypto.GenerateKey()
+	hot := crypto.PubkeyToAddress(hotKey.PublicKey)
+	coldKey, _ := crypto.GenerateKey()
+	cold := crypto.PubkeyToAddress(coldKey.PublicKey)
+	to := common.HexToAddress("0x5597285BbE81BaF351e2C0884e9a5f4416958862")
+
+	signer := types.HomesteadSigner{}
+	allowAll := `function validate(tx) return true end`
+
+	newClient := func() *backends.SimulatedBackend {
+		return backends.NewSimulatedBackend(core.GenesisAlloc{
+			hot:  core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+			cold: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+		}, 4000000)
+	}
+
+	t.Run("Sends from the account given as from", func(t *testing.T) {
+		client := newClient()
+		db := &dbMock{}
+		accounts, err := newKeyring(ctx, client, db, hotKey, coldKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		h := txHandler(client, signer, nil, newTestValidator(t, allowAll), accounts, db)
+
+		for _, c := range []struct {
+			from  string
+			want  common.Address
+			nonce uint64
+		}{{"", hot, 0}, {cold.Hex(), cold, 0}, {"", hot, 1}, {cold.Hex(), cold, 1}} {
+			rr := sendFrom(h, to, c.from, "1", "")
+			if rr.Code != 200 {
+				t.Fatalf("response code = %v, want %v", rr.Code, 200)
+				return
+			}
+			tx, _, err := client.TransactionByHash(ctx, common.HexToHash(rr.Body.String()))
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			if sender, _ := types.Sender(signer, tx); sender != c.want || tx.Nonce() != c.nonce {
+				t.Errorf("sender = %v, nonce = %v, want %v, %v", sender.Hex(), tx.Nonce(), c.want.Hex(), c.nonce)
+			}
+		}
+
+		client.Rollback()
+
+		if db.txs[1].From != cold.Hex() {
+			t.Errorf("recorded sender = %v, want %v", db.txs[1].From, cold.Hex())
+		}
+
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/v1/proxy/transactions/"+db.txs[1].Hash, bytes.NewReader([]byte(`[{"op":"replace","path":"/gasPrice","value":"2000000000"}]`)))
+		retryHandler(client, signer, newTestValidator(t, allowAll), nil, accounts, db).ServeHTTP(rr, req)
+		if rr.Code != 200 {
+			t.Fatalf("retry response code = %v, want %v: %v", rr.Code, 200, rr.Body.String())
+			return
+		}
+		tx, _, err := client.TransactionByHash(ctx, common.HexToHash(rr.Body.String()))
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if sender, _ := types.Sender(signer, tx); sender != cold {
+			t.Errorf("retry sender = %v, want %v", sender.Hex(), cold.Hex())
+		}
+	})
+
+	t.Run("Applies the rules of the account", func(t *testing.T) {
+		client := newClient()
+		db := &dbMock{}
+		small, err := whitelist.NewValidator(`function validate(tx) return tx.valueNumber <= 10, "hot wallet limit" end`, nil, nil, 1, whitelist.DefaultTimeout)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		hotAccount, err := newAccount(ctx, client, db, newLocalSigner(hotKey), "hot", small)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		coldAccount, err := newAccount(ctx, client, db, newLocalSigner(coldKey), "cold", nil)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		h := txHandler(client, signer, nil, newTestValidator(t, `function validate(tx) return tx.valueNumber <= 1000 end`), keyring{hotAccount, coldAccount}, db)
+
+		for _, c := range []struct {
+			from  string
+			value string
+			code  int
+		}{{"", "100", 403}, {"", "10", 200}, {cold.Hex(), "100", 200}, {cold.Hex(), "10000", 403}} {
+			if rr := sendFrom(h, to, c.from, c.value, ""); rr.Code != c.code {
+				t.Errorf("from %v, value %v: response code = %v, want %v", c.from, c.value, rr.Code, c.code)
+			}
+		}
+	})
+}
+
+func Test_keyringAccess(t *testing.T) {
+	ctx := context.Background()
+
+	hotKey, _ := crypto.GenerateKey()
+	hot := crypto.PubkeyToAddress(hotKey.PublicKey)
+	coldKey, _ := crypto.GenerateKey()
+	cold := crypto.PubkeyToAddress(coldKey.PublicKey)
+	to := common.HexToAddress("0x5597285BbE81BaF351e2C0884e9a5f4416958862")
+
+	signer := types.HomesteadSigner{}
+	allowAll := `function validate(tx) return true end`
+
+	newClient := func() *backends.SimulatedBackend {
+		return backends.NewSimulatedBackend(core.GenesisAlloc{
+			hot:  core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+			cold: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+		}, 4000000)
+	}
+
+	t.Run("Refuses the accounts the API key may not use", func(t *testing.T) {
+		client := newClient()
+		db := &dbMock{}
+		accounts, err := newKeyring(ctx, client, db, hotKey, coldKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		keys := apiKeys{
+			"mobile": &apiKey{ID: "mobile", SecretHash: hashSecret("secret"), Enabled: true, Accounts: []string{cold.Hex()}},
+		}
+		h := newAuthenticator(keys, nil, time.Minute, nil, nil).require(scopeSend, txHandler(client, signer, nil, newTestValidator(t, allowAll), accounts, db))
+
+		for _, c := range []struct {
+			from string
+			code int
+		}{{hot.Hex(), 403}, {to.Hex(), 400}, {"0x123", 400}, {"", 200}, {cold.Hex(), 200}} {
+			if rr := sendFrom(h, to, c.from, "1", "mobile"); rr.Code != c.code {
+				t.Errorf("from %v: response code = %v, want %v", c.from, rr.Code, c.code)
+			}
+		}
+		for _, tx := range db.txs {
+			if tx.From != cold.Hex() {
+				t.Errorf("recorded sender = %v, want %v", tx.From, cold.Hex())
+			}
+		}
+
+		if err := accounts.checkAPIKeys(apiKeys{"a": &apiKey{ID: "a", Accounts: []string{to.Hex()}}}); err == nil {
+			t.Errorf("error = %v, want an error for an unknown account", err)
+		}
+	})
+}
+
+func Test_keyringPool(t *testing.T) {
+	ctx := context.Background()
+
+	hotKey, _ := crypto.GenerateKey()
+	hot := crypto.PubkeyToAddress(hotKey.PublicKey)
+	coldKey, _ := crypto.GenerateKey()
+	cold := crypto.PubkeyToAddress(coldKey.PublicKey)
+	to := common.HexToAddress("0x5597285BbE81BaF351e2C0884e9a5f4416958862")
+
+	signer := types.HomesteadSigner{}
+	allowAll := `function validate(tx) return true end`
+
+	t.Run("Picks the least busy account of the pool", func(t *testing.T) {
+		richKey, _ := crypto.GenerateKey()
+		rich := crypto.PubkeyToAddress(richKey.PublicKey)
+		client := backends.NewSimulatedBackend(core.GenesisAlloc{
+			hot:  core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+			cold: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+			rich: core.GenesisAccount{Balance: big.NewInt(2000000000000000000)},
+		}, 4000000)
+		db := &dbMock{}
+		accounts, err := newKeyring(ctx, client, db, coldKey, hotKey, richKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		accounts[1].pool, accounts[2].pool = true, true
+		h := txHandler(client, signer, nil, newTestValidator(t, allowAll), accounts, db)
+
+		for i, c := range []struct {
+			value  string
+			want   common.Address
+			commit bool
+		}{
+			{"1", rich, false},                  // both idle, the richest
+			{"1", hot, false},                   // rich has a pending transaction
+			{"1", rich, false},                  // one pending each, the richest
+			{"1500000000000000000", rich, true}, // hot can't pay it
+			{"1", hot, false},                   // nothing pending, rich spent the most
+		} {
+			if rr := sendFrom(h, to, "", c.value, ""); rr.Code != 200 {
+				t.Fatalf("%v: response code = %v, want %v", i, rr.Code, 200)
+				return
+			}
+			if got := db.txs[i].From; got != c.want.Hex() {
+				t.Errorf("%v: sender = %v, want %v", i, got, c.want.Hex())
+			}
+			if c.commit {
+				client.Commit()
+			}
+		}
+
+		if rr := sendFrom(h, to, cold.Hex(), "1", ""); rr.Code != 200 || db.txs[5].From != cold.Hex() {
+			t.Errorf("response code = %v, sender = %v, want %v", rr.Code, db.txs[5].From, cold.Hex())
+		}
+	})
+}
+
+func Test_loadKeyring(t *testing.T) {
+	ctx := context.Background()
+
+	hotKey, _ := crypto.GenerateKey()
+	hot := crypto.PubkeyToAddress(hotKey.PublicKey)
+	coldKey, _ := crypto.GenerateKey()
+	cold := crypto.PubkeyToAddress(coldKey.PublicKey)
+
+	client := backends.NewSimulatedBackend(core.GenesisAlloc{}, 4000000)
+
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	ks := keystore.NewKeyStore(filepath.Join(dir, "keystore"), keystore.LightScryptN, keystore.LightScryptP)
+	hotAccount, err := ks.ImportECDSA(hotKey, "default pass")
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	coldAccount, err := ks.ImportECDSA(coldKey, "cold pass")
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	hotFile := hotAccount.URL.Path
+	writeTestFile(t, dir, "cold.pass", "cold pass\n")
+	writeTestFile(t, dir, "cold.yaml", "maxValue: \"1000\"\n")
+
+	t.Run("Loads a keyring file", func(t *testing.T) {
+		path := writeTestFile(t, dir, "keyring.yaml", `
+- keystore: `+hotFile+`
+  label: hot wallet
+  pool: true
+- keystore: `+coldAccount.URL.Path+`
+  passphraseFile: cold.pass
+  policy: cold.yaml
+`)
+		accounts, err := loadKeyring(ctx, path, "default pass", "", defaultClefTimeout, client, &dbMock{}, nil, whitelist.DefaultTimeout)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if len(accounts) != 2 || accounts[0].address != hot || accounts[0].label != "hot wallet" || accounts[1].address != cold {
+			t.Fatalf("accounts = %+v", accounts)
+			return
+		}
+		if !accounts[0].pool || accounts[1].pool {
+			t.Errorf("pool = %v, %v, want only the hot wallet", accounts[0].pool, accounts[1].pool)
+		}
+		if accounts[0].rules != nil || accounts[1].rules == nil {
+			t.Errorf("rules = %v, %v, want only the cold wallet rules", accounts[0].rules, accounts[1].rules)
+		}
+	})
+
+	t.Run("Refuses invalid keyring files", func(t *testing.T) {
+		for _, content := range []string{
+			``,
+			`[{"keystore": "` + hotFile + `", "clef": "http://127.0.0.1:8550", "address": "` + hot.Hex() + `"}]`,
+			`[{"clef": "http://127.0.0.1:8550"}]`,
+			`[{"clef": "http://127.0.0.1:8550", "vault": "http://127.0.0.1:8200/v1/transit", "address": "` + hot.Hex() + `"}]`,
+			`[{"vault": "http://127.0.0.1:8200/v1/transit", "address": "` + hot.Hex() + `"}]`,
+			`[{"label": "no key"}]`,
+			`[{"keystore": "` + hotFile + `", "passphraseFile": "cold.pass"}]`,
+			`[{"keystore": "` + hotFile + `"}, {"keystore": "` + hotFile + `"}]`,
+			`[{"keystore": "missing.json"}]`,
+			`[{"keystore": "` + hotFile + `", "rules": "missing.lua"}]`,
+			`[{"keystore": "` + hotFile + `", "passphrase": "default pass"}]`,
+		} {
+			_, err := loadKeyring(ctx, writeTestFile(t, dir, "bad.yaml", content), "default pass", "", defaultClefTimeout, client, &dbMock{}, nil, whitelist.DefaultTimeout)
+			if err == nil {
+				t.Errorf("%v: error = %v, want an error", content, err)
+			}
+		}
+	})
+}
+
+func Test_loadRemoteKeyring(t *testing.T) {
+	ctx := context.Background()
+
+	hotKey, _ := crypto.GenerateKey()
+	hot := crypto.PubkeyToAddress(hotKey.PublicKey)
+	coldKey, _ := crypto.GenerateKey()
+	cold := crypto.PubkeyToAddress(coldKey.PublicKey)
+
+	client := backends.NewSimulatedBackend(core.GenesisAlloc{}, 4000000)
+
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("Loads remote signers", func(t *testing.T) {
+		writeTestFile(t, dir, "vault.token", "s.token\n")
+		path := writeTestFile(t, dir, "remote.yaml", `
+- address: `+hot.Hex()+`
+  clef: http://127.0.0.1:8550
+- address: `+cold.Hex()+`
+  vault: https://127.0.0.1:8200/v1/transit
+  vaultKey: cold
+  vaultTokenFile: vault.token
+`)
+		accounts, err := loadKeyring(ctx, path, "", "", defaultClefTimeout, client, &dbMock{}, nil, whitelist.DefaultTimeout)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if _, ok := accounts[0].signer.(*clefSigner); !ok || accounts[0].address != hot {
+			t.Errorf("signer = %T %v, want a Clef signer for %v", accounts[0].signer, accounts[0].address.Hex(), hot.Hex())
+		}
+		if v, ok := accounts[1].signer.(*vaultSigner); !ok || v.token != "s.token" || v.key != "cold" || accounts[1].address != cold {
+			t.Errorf("signer = %+v, want a Vault signer for %v", accounts[1].signer, cold.Hex())
+		}
+	})
+}
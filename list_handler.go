@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+	"github.com/ethereum/go-ethereum/common"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+func listHandler(db Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseTxFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if k := callerOf(r); k != nil && !k.isAdmin() {
+			f.APIKey = k.ID
+		}
+
+		// Fetch one more row to know if there is a next page
+		limit := f.Limit
+		f.Limit++
+		txs, err := db.ListTransactions(f)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err.Error(),
+			}).Error("Error listing transactions.")
+			http.Error(w, "error listing transactions: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		list := sss.TransactionList{Transactions: []sss.Transaction{}}
+		if len(txs) > limit {
+			txs = txs[:limit]
+			list.NextCursor = strconv.FormatUint(uint64(txs[limit-1].ID), 10)
+		}
+		ids := make([]uint, len(txs))
+		for i, tx := range txs {
+			ids[i] = tx.ID
+		}
+		previousHashes, err := db.PreviousHashesOf(ids)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err.Error(),
+			}).Error("Error reading previous hashes.")
+			http.Error(w, "error listing transactions: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, tx := range txs {
+			list.Transactions = append(list.Transactions, transactionJSON(tx, previousHashes[tx.ID]))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// parseTxFilter reads the filters of a listing from the query string
+func parseTxFilter(q url.Values) (txFilter, error) {
+	f := txFilter{Limit: defaultListLimit}
+
+	if from := q.Get("from"); from != "" {
+		if !common.IsHexAddress(from) {
+			return f, errInvalidParam("from")
+		}
+		f.From = from
+	}
+
+	if to := q.Get("to"); to != "" {
+		if !common.IsHexAddress(to) {
+			return f, errInvalidParam("to")
+		}
+		f.To = to
+	}
+
+	switch status := q.Get("status"); status {
+	case "", txStatusPending, txStatusMined, txStatusFailed, txStatusDropped:
+		f.Status = status
+	default:
+		return f, errInvalidParam("status")
+	}
+
+	var err error
+	f.MinNonce, err = parseNonce(q, "minNonce")
+	if err != nil {
+		return f, err
+	}
+	f.MaxNonce, err = parseNonce(q, "maxNonce")
+	if err != nil {
+		return f, err
+	}
+
+	f.CreatedAfter, err = parseTime(q, "createdAfter")
+	if err != nil {
+		return f, err
+	}
+	f.CreatedBefore, err = parseTime(q, "createdBefore")
+	if err != nil {
+		return f, err
+	}
+
+	if v := q.Get("contractCreation"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, errInvalidParam("contractCreation")
+		}
+		f.ContractCreation = b
+	}
+
+	return f, parsePage(q, &f)
+}
+
+// parseNonce reads an optional nonce bound
+func parseNonce(q url.Values, name string) (*uint64, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return nil, errInvalidParam(name)
+	}
+	return &n, nil
+}
+
+// parseTime reads an optional RFC 3339 time bound
+func parseTime(q url.Values, name string) (time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, errInvalidParam(name)
+	}
+	return t, nil
+}
+
+// parsePage reads the cursor and the size of the page
+func parsePage(q url.Values, f *txFilter) error {
+	if v := q.Get("cursor"); v != "" {
+		c, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || c == 0 {
+			return errInvalidParam("cursor")
+		}
+		f.Cursor = uint(c)
+	}
+
+	if v := q.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > maxListLimit {
+			return errInvalidParam("limit")
+		}
+		f.Limit = l
+	}
+	return nil
+}
+
+type errInvalidParam string
+
+func (e errInvalidParam) Error() string {
+	return "invalid query parameter: " + string(e)
+}
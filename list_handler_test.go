@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+)
+
+// newListTestDB records five transactions an hour apart, nonce 3 a contract
+// creation, nonce 4 pending, nonce 1 sent by alice and replaced twice, nonce 2
+// sent by bob
+func newListTestDB() *dbMock {
+	to := "0x5597285BbE81BaF351e2C0884e9a5f4416958862"
+	created := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	db := &dbMock{}
+	for i := 0; i < 5; i++ {
+		tx := &transaction{Nonce: uint64(i), To: to, Hash: fmt.Sprintf("0x%064x", i), Status: txStatusMined}
+		tx.CreatedAt = created.Add(time.Duration(i) * time.Hour)
+		db.Create(tx)
+	}
+	db.txs[3].To = ""
+	db.txs[4].Status = txStatusPending
+
+	db.txs[1].APIKey = "alice"
+	db.txs[2].APIKey = "bob"
+	db.Create(&txHash{TransactionID: db.txs[1].ID, Hash: "0x1a"})
+	db.Create(&txHash{TransactionID: db.txs[1].ID, Hash: "0x1b"})
+	return db
+}
+
+// listAs lists the transactions as the caller k, or without a caller if k is
+// nil
+func listAs(t *testing.T, db *dbMock, k *apiKey, query string) (int, sss.TransactionList) {
+	req, err := http.NewRequest("GET", "/v1/proxy/transactions?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k != nil {
+		req = withCaller(req, k)
+	}
+	rr := httptest.NewRecorder()
+	listHandler(onePreviousHashesQuery{db, t}).ServeHTTP(rr, req)
+
+	var l sss.TransactionList
+	if rr.Code == 200 {
+		err = json.NewDecoder(rr.Body).Decode(&l)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rr.Code, l
+}
+
+// listedNonces are the nonces of a list, in order
+func listedNonces(l sss.TransactionList) []uint64 {
+	ns := []uint64{}
+	for _, tx := range l.Transactions {
+		ns = append(ns, tx.Nonce)
+	}
+	return ns
+}
+
+func Test_list(t *testing.T) {
+	db := newListTestDB()
+	list := func(t *testing.T, query string) (int, sss.TransactionList) {
+		return listAs(t, db, nil, query)
+	}
+
+	for _, c := range []struct {
+		name  string
+		query string
+		want  []uint64
+	}{
+		{"Lists newest first", "", []uint64{4, 3, 2, 1, 0}},
+		{"Filters by destination", "to=0x5597285bbe81baf351e2c0884e9a5f4416958862", []uint64{4, 2, 1, 0}},
+		{"Filters by status", "status=pending", []uint64{4}},
+		{"Filters by nonce range", "minNonce=1&maxNonce=2", []uint64{2, 1}},
+		{"Filters by creation time", "createdAfter=2019-07-01T01:00:00Z&createdBefore=2019-07-01T03:00:00Z", []uint64{2, 1}},
+		{"Filters contract creations", "contractCreation=true", []uint64{3}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			code, l := list(t, c.query)
+			if code != 200 {
+				t.Errorf("response code = %v, want %v", code, 200)
+				return
+			}
+			if got := listedNonces(l); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("nonces = %v, want %v", got, c.want)
+			}
+		})
+	}
+
+	t.Run("Paginates with a cursor", func(t *testing.T) {
+		var got []uint64
+		query := "limit=2"
+		for pages := 0; pages < 5; pages++ {
+			code, l := list(t, query)
+			if code != 200 {
+				t.Errorf("response code = %v, want %v", code, 200)
+				return
+			}
+			got = append(got, listedNonces(l)...)
+			if l.NextCursor == "" {
+				break
+			}
+			query = "limit=2&cursor=" + l.NextCursor
+		}
+
+		want := []uint64{4, 3, 2, 1, 0}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("nonces = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Rejects invalid filters", func(t *testing.T) {
+		for _, query := range []string{"to=abc", "status=lost", "minNonce=-1", "createdAfter=yesterday", "limit=0", "cursor=x"} {
+			if code, _ := list(t, query); code != 400 {
+				t.Errorf("%v: response code = %v, want %v", query, code, 400)
+			}
+		}
+	})
+
+}
+
+func Test_listAccess(t *testing.T) {
+	db := newListTestDB()
+
+	t.Run("Lists the previous hashes", func(t *testing.T) {
+		_, l := listAs(t, db, nil, "")
+		for _, tx := range l.Transactions {
+			want := []string{}
+			if tx.Nonce == 1 {
+				want = []string{"0x1a", "0x1b"}
+			}
+			if !reflect.DeepEqual(tx.PreviousHashes, want) {
+				t.Errorf("%v: previous hashes = %v, want %v", tx.Nonce, tx.PreviousHashes, want)
+			}
+		}
+	})
+
+	t.Run("Lists only the transactions of the caller", func(t *testing.T) {
+		for _, c := range []struct {
+			key  *apiKey
+			want []uint64
+		}{
+			{&apiKey{ID: "alice"}, []uint64{1}},
+			{&apiKey{ID: "bob", Scopes: []string{scopeRead}}, []uint64{2}},
+			{&apiKey{ID: "carol"}, []uint64{}},
+			{&apiKey{ID: "root", Scopes: []string{scopeRead, scopeAdmin}}, []uint64{4, 3, 2, 1, 0}},
+		} {
+			code, l := listAs(t, db, c.key, "")
+			if code != 200 {
+				t.Errorf("%v: response code = %v, want %v", c.key.ID, code, 200)
+				continue
+			}
+			if got := listedNonces(l); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("%v: nonces = %v, want %v", c.key.ID, got, c.want)
+			}
+		}
+	})
+}
+
+// onePreviousHashesQuery fails the test when the previous hashes are read
+// transaction by transaction
+type onePreviousHashesQuery struct {
+	*dbMock
+	t *testing.T
+}
+
+func (m onePreviousHashesQuery) PreviousHashes(id uint) ([]string, error) {
+	m.t.Errorf("PreviousHashes(%v) called, want one PreviousHashesOf for the page", id)
+	return m.dbMock.PreviousHashes(id)
+}
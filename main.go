@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	"math/big"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/WeTrustPlatform/secure-signing-serv/whitelist"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 
@@ -18,7 +25,7 @@ import (
 func main() {
 	logInit()
 
-	for _, v := range []string{"RPC_ENDPOINT", "PRIV_KEY", "PASSPHRASE", "PORT", "BASIC_AUTH_USER", "BASIC_AUTH_PASS", "CHAIN_ID"} {
+	for _, v := range []string{"RPC_ENDPOINT", "PORT", "CHAIN_ID"} {
 		if os.Getenv(v) == "" {
 			panic("Environment variable not set: " + v)
 		}
@@ -33,46 +40,512 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	db.AutoMigrate(&transaction{})
+	db.AutoMigrate(&transaction{}, &txHash{})
+	recorder := gormRecorder{db}
 
-	keyJSON := os.Getenv("PRIV_KEY")
-	pass := os.Getenv("PASSPHRASE")
-	key, err := keystore.DecryptKey([]byte(keyJSON), pass)
+	abis := abisFromEnv()
+	rulesTimeout := durationFromEnv("RULES_TIMEOUT", whitelist.DefaultTimeout)
+
+	chainID, ok := big.NewInt(0).SetString(os.Getenv("CHAIN_ID"), 10)
+	if !ok {
+		panic("Can't parse CHAIN_ID")
+	}
+	signer := types.NewLondonSigner(chainID)
+
+	accounts := keyringFromEnv(client, recorder, abis, rulesTimeout)
+	assignLegacySender(accounts, recorder)
+	for _, a := range accounts {
+		log.WithFields(log.Fields{
+			"From":  a.address.Hex(),
+			"Label": a.label,
+		}).Info("Signing account")
+	}
+
+	// Files read again on SIGHUP
+	var reloads []func() error
+
+	rules, reload := rulesFromEnv(abis, recorder, rulesTimeout, accounts[0].address, chainID)
+	if reload != nil {
+		reloads = append(reloads, reload)
+	}
+
+	keys := apiKeysFromEnv(abis, recorder, rulesTimeout)
+	err = accounts.checkAPIKeys(keys)
 	if err != nil {
 		panic(err)
 	}
+	var certScopes []string
+	if os.Getenv("TLS_CLIENT_CERT_SCOPES") != "" {
+		certScopes = strings.Fields(os.Getenv("TLS_CLIENT_CERT_SCOPES"))
+	}
+	maxSkew := durationFromEnv("HMAC_MAX_SKEW", 5*time.Minute)
+	auth := newAuthenticator(keys, authThrottleFromEnv(), maxSkew, jwtVerifierFromEnv(), certScopes)
 
-	rules := os.Getenv("RULES")
+	pollInterval := durationFromEnv("RECEIPT_POLL_INTERVAL", 15*time.Second)
+	go newReceiptWatcher(client, recorder).Run(context.Background(), pollInterval)
 
-	chainID, ok := big.NewInt(0).SetString(os.Getenv("CHAIN_ID"), 10)
-	if !ok {
-		panic("Can't parse CHAIN_ID")
+	if policy, ok := bumpPolicyFromEnv(); ok {
+		go newRebroadcaster(
+			client,
+			signer,
+			rules,
+			keys,
+			accounts,
+			recorder,
+			policy).Run(context.Background(), pollInterval)
 	}
-	signer := types.NewEIP155Signer(chainID)
 
-	http.HandleFunc("/v1/proxy/transactions", basicAuth(txHandler(
-		client,
-		signer,
-		rules,
-		key.Address,
-		key.PrivateKey,
-		db)))
+	oracle := feeOracleFromEnv(client)
+
+	http.HandleFunc("/v1/proxy/transactions", byMethod(map[string]http.HandlerFunc{
+		"GET": auth.require(scopeRead, listHandler(recorder)),
+		"POST": auth.require(scopeSend, txHandler(
+			client,
+			signer,
+			oracle,
+			rules,
+			accounts,
+			recorder)),
+	}))
+
+	http.HandleFunc("/v1/proxy/transactions:validate", byMethod(map[string]http.HandlerFunc{
+		"POST": auth.require(scopeSend, validateHandler(
+			client,
+			signer,
+			oracle,
+			rules,
+			accounts)),
+	}))
 
-	http.HandleFunc("/v1/proxy/transactions/*", basicAuth(retryHandler(
-		client,
-		signer,
-		rules,
-		key.Address,
-		key.PrivateKey,
-		db)))
+	http.HandleFunc("/v1/proxy/transactions/", byMethod(map[string]http.HandlerFunc{
+		"GET": auth.require(scopeRead, getHandler(recorder)),
+		"PATCH": auth.require(scopeRetry, retryHandler(
+			client,
+			signer,
+			rules,
+			keys,
+			accounts,
+			recorder)),
+	}))
+
+	certs := certsFromEnv()
+	if certs != nil {
+		reloads = append(reloads, certs.Reload)
+	}
+	reloadOnHangup(reloads)
 
 	log.WithFields(log.Fields{
 		"PORT": os.Getenv("PORT"),
+		"TLS":  certs != nil,
 	}).Info("Listening")
 
+	if certs != nil {
+		server := &http.Server{Addr: ":" + os.Getenv("PORT"), TLSConfig: certs.config()}
+		panic(server.ListenAndServeTLS("", ""))
+	}
 	http.ListenAndServe(":"+os.Getenv("PORT"), nil)
 }
 
+// durationFromEnv parses a duration setting, def if it isn't set
+func durationFromEnv(name string, def time.Duration) time.Duration {
+	if os.Getenv(name) == "" {
+		return def
+	}
+	d, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		panic("Can't parse " + name)
+	}
+	return d
+}
+
+// abisFromEnv reads the ABIs the rules decode calls with
+func abisFromEnv() whitelist.ABIRegistry {
+	if os.Getenv("CONTRACT_ABIS") == "" {
+		return whitelist.ABIRegistry{}
+	}
+	f, err := os.Open(os.Getenv("CONTRACT_ABIS"))
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	abis, err := whitelist.LoadABIRegistry(f)
+	if err != nil {
+		panic(err)
+	}
+	return abis
+}
+
+// keyringFromEnv loads the accounts of KEYRING_FILE, or the single account of
+// PRIV_KEY
+func keyringFromEnv(client Client, recorder gormRecorder, abis whitelist.ABIRegistry, rulesTimeout time.Duration) keyring {
+	if os.Getenv("KEYRING_FILE") != "" {
+		clefTimeout := durationFromEnv("CLEF_TIMEOUT", defaultClefTimeout)
+		if clefTimeout <= 0 {
+			panic("Can't parse CLEF_TIMEOUT")
+		}
+		accounts, err := loadKeyring(context.Background(), os.Getenv("KEYRING_FILE"), os.Getenv("PASSPHRASE"), os.Getenv("VAULT_TOKEN"), clefTimeout, client, recorder, abis, rulesTimeout)
+		if err != nil {
+			panic(err)
+		}
+		return accounts
+	}
+
+	for _, v := range []string{"PRIV_KEY", "PASSPHRASE"} {
+		if os.Getenv(v) == "" {
+			panic("Environment variable not set: " + v)
+		}
+	}
+	key, err := keystore.DecryptKey([]byte(os.Getenv("PRIV_KEY")), os.Getenv("PASSPHRASE"))
+	if err != nil {
+		panic(err)
+	}
+	accounts, err := newKeyring(context.Background(), client, recorder, key.PrivateKey)
+	if err != nil {
+		panic(err)
+	}
+	return accounts
+}
+
+// assignLegacySender records the sender of the transactions recorded before
+// the keyring. They were sent by the account of PRIV_KEY, or by LEGACY_SENDER
+// which must be in the keyring.
+func assignLegacySender(accounts keyring, recorder gormRecorder) {
+	var legacy *account
+	if os.Getenv("LEGACY_SENDER") != "" {
+		legacySender := os.Getenv("LEGACY_SENDER")
+		if !common.IsHexAddress(legacySender) {
+			panic("Can't parse LEGACY_SENDER")
+		}
+		var ok bool
+		legacy, ok = accounts.get(common.HexToAddress(legacySender))
+		if !ok {
+			panic("LEGACY_SENDER is not an account of the keyring: " + legacySender)
+		}
+	} else if os.Getenv("KEYRING_FILE") == "" {
+		legacy = accounts[0]
+	}
+	if legacy == nil {
+		return
+	}
+
+	err := recorder.AssignSender(legacy.address)
+	if err != nil {
+		panic(err)
+	}
+	// Its nonces were synced without them
+	err = legacy.nonces.Sync(context.Background())
+	if err != nil {
+		panic(err)
+	}
+}
+
+// rulesFromEnv builds the rules transactions are checked with: the policy
+// first, then the Lua rules if any. It also returns how to reload them when
+// they come from RULES_FILE.
+func rulesFromEnv(
+	abis whitelist.ABIRegistry,
+	recorder gormRecorder,
+	rulesTimeout time.Duration,
+	from common.Address,
+	chainID *big.Int,
+) (whitelist.Rules, func() error) {
+	samples := samplesFromEnv()
+	policy := policyFromEnv()
+
+	if os.Getenv("RULES_FILE") != "" {
+		// The file checks the samples with the policy on every reload
+		file, err := whitelist.NewFile(os.Getenv("RULES_FILE"), abis, recorder, whitelist.PoolSize, rulesTimeout, policy, samples, from, chainID)
+		if err != nil {
+			panic(err)
+		}
+		if policy != nil {
+			return whitelist.All{policy, file}, file.Reload
+		}
+		return file, file.Reload
+	}
+
+	var rules whitelist.Rules
+	if os.Getenv("RULES") != "" || policy == nil {
+		var err error
+		rules, err = whitelist.NewValidator(os.Getenv("RULES"), abis, recorder, whitelist.PoolSize, rulesTimeout)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if policy != nil && rules != nil {
+		rules = whitelist.All{policy, rules}
+	} else if policy != nil {
+		rules = policy
+	}
+	err := whitelist.CheckSamples(rules, samples, from, chainID)
+	if err != nil {
+		panic(err)
+	}
+	return rules, nil
+}
+
+// samplesFromEnv reads the transactions the rules are checked against
+func samplesFromEnv() []whitelist.Sample {
+	if os.Getenv("RULES_SAMPLES") == "" {
+		return nil
+	}
+	f, err := os.Open(os.Getenv("RULES_SAMPLES"))
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	samples, err := whitelist.LoadSamples(f)
+	if err != nil {
+		panic(err)
+	}
+	return samples
+}
+
+// policyFromEnv reads the declarative policy. It returns nil when
+// POLICY_FILE isn't set.
+func policyFromEnv() whitelist.Rules {
+	if os.Getenv("POLICY_FILE") == "" {
+		return nil
+	}
+	f, err := os.Open(os.Getenv("POLICY_FILE"))
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	policy, err := whitelist.LoadPolicy(f)
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+// apiKeysFromEnv loads the API keys of KEYS_FILE, or the single key of the
+// BASIC_AUTH_ variables
+func apiKeysFromEnv(abis whitelist.ABIRegistry, recorder gormRecorder, rulesTimeout time.Duration) apiKeys {
+	if os.Getenv("KEYS_FILE") != "" {
+		keys, err := loadAPIKeys(os.Getenv("KEYS_FILE"), abis, recorder, rulesTimeout)
+		if err != nil {
+			panic(err)
+		}
+		return keys
+	}
+
+	if os.Getenv("BASIC_AUTH_USER") == "" {
+		panic("Environment variable not set: BASIC_AUTH_USER")
+	}
+	secretHash := os.Getenv("BASIC_AUTH_PASS_HASH")
+	if secretHash == "" && os.Getenv("BASIC_AUTH_PASS") != "" {
+		secretHash = hashSecret(os.Getenv("BASIC_AUTH_PASS"))
+	}
+	if secretHash == "" && os.Getenv("HMAC_SECRET") == "" {
+		panic("Environment variable not set: BASIC_AUTH_PASS_HASH, BASIC_AUTH_PASS or HMAC_SECRET")
+	}
+	keys, err := envAPIKeys(os.Getenv("BASIC_AUTH_USER"), secretHash, os.Getenv("HMAC_SECRET"))
+	if err != nil {
+		panic(err)
+	}
+	return keys
+}
+
+// jwtVerifierFromEnv reads the settings of the bearer tokens. It returns nil
+// when neither JWKS_FILE nor JWKS_URL is set.
+func jwtVerifierFromEnv() *jwtVerifier {
+	source := os.Getenv("JWKS_FILE")
+	if source == "" {
+		source = os.Getenv("JWKS_URL")
+	}
+	if source == "" {
+		return nil
+	}
+	scopeClaim := os.Getenv("JWT_SCOPE_CLAIM")
+	if scopeClaim == "" {
+		scopeClaim = "scope"
+	}
+	bearer, err := newJWTVerifier(source, os.Getenv("JWT_ISSUER"), os.Getenv("JWT_AUDIENCE"), scopeClaim)
+	if err != nil {
+		panic(err)
+	}
+	return bearer
+}
+
+// certsFromEnv loads the TLS certificates. It returns nil when TLS_CERT_FILE
+// isn't set.
+func certsFromEnv() *certReloader {
+	if os.Getenv("TLS_CERT_FILE") == "" {
+		return nil
+	}
+	requireClientCert := true
+	switch os.Getenv("TLS_CLIENT_AUTH") {
+	case "", "require":
+	case "optional":
+		requireClientCert = false
+	default:
+		panic("TLS_CLIENT_AUTH must be require or optional")
+	}
+	certs, err := newCertReloader(os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"), os.Getenv("TLS_CLIENT_CA_FILE"), requireClientCert)
+	if err != nil {
+		panic(err)
+	}
+	return certs
+}
+
+// reloadOnHangup reads the files again on SIGHUP
+func reloadOnHangup(reloads []func() error) {
+	if len(reloads) == 0 {
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			for _, reload := range reloads {
+				reload()
+			}
+		}
+	}()
+}
+
+// feeOracleFromEnv reads the strategy pricing transactions sent without fees.
+// It returns nil when FEE_ORACLE isn't set.
+func feeOracleFromEnv(client Client) feeOracle {
+	var oracle feeOracle
+	switch os.Getenv("FEE_ORACLE") {
+	case "":
+		return nil
+	case "node":
+		oracle = nodeOracle{client}
+	case "percentile":
+		blocks, percentile := 20, 60
+		var err error
+		if os.Getenv("FEE_ORACLE_BLOCKS") != "" {
+			blocks, err = strconv.Atoi(os.Getenv("FEE_ORACLE_BLOCKS"))
+			if err != nil {
+				panic("Can't parse FEE_ORACLE_BLOCKS")
+			}
+		}
+		if os.Getenv("FEE_ORACLE_PERCENTILE") != "" {
+			percentile, err = strconv.Atoi(os.Getenv("FEE_ORACLE_PERCENTILE"))
+			if err != nil {
+				panic("Can't parse FEE_ORACLE_PERCENTILE")
+			}
+		}
+		oracle, err = newPercentileOracle(client, blocks, percentile)
+		if err != nil {
+			panic(err)
+		}
+	case "fixed":
+		price, ok := big.NewInt(0).SetString(os.Getenv("FEE_ORACLE_PRICE"), 10)
+		if !ok {
+			panic("Can't parse FEE_ORACLE_PRICE")
+		}
+		oracle = fixedOracle{price}
+	default:
+		panic("FEE_ORACLE must be node, percentile or fixed")
+	}
+
+	var min, max *big.Int
+	var ok bool
+	if os.Getenv("FEE_ORACLE_MIN") != "" {
+		min, ok = big.NewInt(0).SetString(os.Getenv("FEE_ORACLE_MIN"), 10)
+		if !ok {
+			panic("Can't parse FEE_ORACLE_MIN")
+		}
+	}
+	if os.Getenv("FEE_ORACLE_MAX") != "" {
+		max, ok = big.NewInt(0).SetString(os.Getenv("FEE_ORACLE_MAX"), 10)
+		if !ok {
+			panic("Can't parse FEE_ORACLE_MAX")
+		}
+	}
+	capped, err := newCappedOracle(oracle, min, max)
+	if err != nil {
+		panic(err)
+	}
+	return capped
+}
+
+// bumpPolicyFromEnv reads the automatic gas price bumping settings. Bumping is
+// enabled when BUMP_AFTER_BLOCKS or BUMP_AFTER is set.
+func bumpPolicyFromEnv() (bumpPolicy, bool) {
+	policy := bumpPolicy{Percent: 10}
+	if os.Getenv("BUMP_AFTER_BLOCKS") == "" && os.Getenv("BUMP_AFTER") == "" {
+		return policy, false
+	}
+
+	var err error
+	if os.Getenv("BUMP_AFTER_BLOCKS") != "" {
+		policy.AfterBlocks, err = strconv.ParseUint(os.Getenv("BUMP_AFTER_BLOCKS"), 10, 64)
+		if err != nil {
+			panic("Can't parse BUMP_AFTER_BLOCKS")
+		}
+	}
+	if os.Getenv("BUMP_AFTER") != "" {
+		policy.After, err = time.ParseDuration(os.Getenv("BUMP_AFTER"))
+		if err != nil {
+			panic("Can't parse BUMP_AFTER")
+		}
+	}
+	if os.Getenv("BUMP_PERCENT") != "" {
+		policy.Percent, err = strconv.ParseInt(os.Getenv("BUMP_PERCENT"), 10, 64)
+		if err != nil || policy.Percent <= 0 {
+			panic("Can't parse BUMP_PERCENT")
+		}
+		if policy.Percent < replaceBump {
+			panic("BUMP_PERCENT must be at least 10 for nodes to accept the replacements")
+		}
+	}
+
+	var ok bool
+	policy.MaxGasPrice, ok = big.NewInt(0).SetString(os.Getenv("BUMP_MAX_GAS_PRICE"), 10)
+	if !ok {
+		panic("Can't parse BUMP_MAX_GAS_PRICE")
+	}
+
+	return policy, true
+}
+
+// authThrottleFromEnv reads the lockout of failing authentications. It returns
+// nil when AUTH_MAX_FAILURES is 0.
+func authThrottleFromEnv() *authThrottle {
+	maxFailures := 10
+	window, lockout := 15*time.Minute, 15*time.Minute
+	var err error
+	if os.Getenv("AUTH_MAX_FAILURES") != "" {
+		maxFailures, err = strconv.Atoi(os.Getenv("AUTH_MAX_FAILURES"))
+		if err != nil || maxFailures < 0 {
+			panic("Can't parse AUTH_MAX_FAILURES")
+		}
+	}
+	if maxFailures == 0 {
+		return nil
+	}
+	if os.Getenv("AUTH_FAILURE_WINDOW") != "" {
+		window, err = time.ParseDuration(os.Getenv("AUTH_FAILURE_WINDOW"))
+		if err != nil {
+			panic("Can't parse AUTH_FAILURE_WINDOW")
+		}
+	}
+	if os.Getenv("AUTH_LOCKOUT") != "" {
+		lockout, err = time.ParseDuration(os.Getenv("AUTH_LOCKOUT"))
+		if err != nil {
+			panic("Can't parse AUTH_LOCKOUT")
+		}
+	}
+	return newAuthThrottle(maxFailures, window, lockout, os.Getenv("TRUST_PROXY_HEADERS") == "true")
+}
+
+// byMethod dispatches the requests of a route to a handler per HTTP method
+func byMethod(handlers map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h, ok := handlers[r.Method]
+		if !ok {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+}
+
 func logInit() {
 	// Setup log formatter. Default is JSON for production and staging.
 	switch os.Getenv("LOG_FORMATTER") {
@@ -1,24 +1,238 @@
 package main
 
 import (
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/whitelist"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/jinzhu/gorm"
 )
 
 type dbMock struct {
-	txs []*transaction
+	mu     sync.Mutex
+	txs    []*transaction
+	hashes []*txHash
 }
 
 func (m *dbMock) Create(r interface{}) *gorm.DB {
-	tx := r.(*transaction)
-	m.txs = append(m.txs, tx)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch t := r.(type) {
+	case *transaction:
+		t.ID = uint(len(m.txs) + 1)
+		if t.CreatedAt.IsZero() {
+			t.CreatedAt = time.Now()
+		}
+		m.txs = append(m.txs, t)
+	case *txHash:
+		t.ID = uint(len(m.hashes) + 1)
+		m.hashes = append(m.hashes, t)
+	}
 	return nil
 }
 
 func (m *dbMock) First(a interface{}, b ...interface{}) *gorm.DB {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	*a.(*transaction) = *m.txs[0]
 	return nil
 }
 
 func (m *dbMock) Save(a interface{}) *gorm.DB {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tx := *a.(*transaction)
+	for i, t := range m.txs {
+		if t.ID == tx.ID {
+			m.txs[i] = &tx
+		}
+	}
+	return nil
+}
+
+func (m *dbMock) PendingTransactions() ([]transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var txs []transaction
+	for _, tx := range m.txs {
+		if tx.Status == txStatusPending || tx.Status == "" {
+			txs = append(txs, *tx)
+		}
+	}
+	return txs, nil
+}
+
+func (m *dbMock) TransactionByHash(hash string) (transaction, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := uint(0)
+	for _, h := range m.hashes {
+		if h.Hash == hash {
+			id = h.TransactionID
+		}
+	}
+	for _, tx := range m.txs {
+		if tx.Hash == hash || (id != 0 && tx.ID == id) {
+			return *tx, true, nil
+		}
+	}
+	return transaction{}, false, nil
+}
+
+func (m *dbMock) PreviousHashes(id uint) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var hashes []string
+	for _, h := range m.hashes {
+		if h.TransactionID == id {
+			hashes = append(hashes, h.Hash)
+		}
+	}
+	return hashes, nil
+}
+
+func (m *dbMock) PreviousHashesOf(ids []uint) (map[uint][]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hashes := map[uint][]string{}
+	for _, h := range m.hashes {
+		for _, id := range ids {
+			if h.TransactionID == id {
+				hashes[id] = append(hashes[id], h.Hash)
+			}
+		}
+	}
+	return hashes, nil
+}
+
+func (m *dbMock) ReplaceHash(id uint, old, new string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, h := range m.hashes {
+		if h.TransactionID == id && h.Hash == old {
+			h.Hash = new
+		}
+	}
 	return nil
 }
+
+func (m *dbMock) SetBroadcastBlock(id uint, hash string, block uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.txs {
+		if t.ID == id && t.Hash == hash {
+			t.BroadcastBlock = block
+		}
+	}
+	return nil
+}
+
+func (m *dbMock) RecordOutcome(tx transaction, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.txs {
+		if t.ID == tx.ID && t.Hash == hash {
+			t.Hash = tx.Hash
+			t.GasPrice = tx.GasPrice
+			t.MaxFeePerGas = tx.MaxFeePerGas
+			t.MaxPriorityFeePerGas = tx.MaxPriorityFeePerGas
+			t.Status = tx.Status
+			t.BlockNumber = tx.BlockNumber
+			t.BlockHash = tx.BlockHash
+			t.GasUsed = tx.GasUsed
+			t.Fee = tx.Fee
+		}
+	}
+	return nil
+}
+
+func (m *dbMock) RecordReplacement(tx transaction, replaced string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.txs {
+		if t.ID == tx.ID && t.Hash == replaced {
+			t.Hash = tx.Hash
+			t.GasPrice = tx.GasPrice
+			t.MaxFeePerGas = tx.MaxFeePerGas
+			t.MaxPriorityFeePerGas = tx.MaxPriorityFeePerGas
+			t.Status = tx.Status
+			t.BroadcastAt = tx.BroadcastAt
+			t.BroadcastBlock = tx.BroadcastBlock
+		}
+	}
+	return nil
+}
+
+func (m *dbMock) ListTransactions(f txFilter) ([]transaction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var txs []transaction
+	for i := len(m.txs) - 1; i >= 0 && len(txs) < f.Limit; i-- {
+		if matchesFilter(*m.txs[i], f) {
+			txs = append(txs, *m.txs[i])
+		}
+	}
+	return txs, nil
+}
+
+// matchesFilter tells if ListTransactions would return a transaction
+func matchesFilter(tx transaction, f txFilter) bool {
+	status := tx.Status
+	if status == "" {
+		status = txStatusPending
+	}
+	switch {
+	case f.From != "" && !strings.EqualFold(tx.From, f.From):
+	case f.To != "" && !sameAddress(tx.To, f.To):
+	case f.Status != "" && status != f.Status:
+	case f.ContractCreation && tx.To != "":
+	case f.APIKey != "" && tx.APIKey != f.APIKey:
+	case f.Cursor != 0 && tx.ID >= f.Cursor:
+	default:
+		return inRanges(tx, f)
+	}
+	return false
+}
+
+// inRanges tells if a transaction is within the nonce and creation time
+// ranges of a filter
+func inRanges(tx transaction, f txFilter) bool {
+	switch {
+	case f.MinNonce != nil && tx.Nonce < *f.MinNonce:
+	case f.MaxNonce != nil && tx.Nonce > *f.MaxNonce:
+	case !f.CreatedAfter.IsZero() && tx.CreatedAt.Before(f.CreatedAfter):
+	case !f.CreatedBefore.IsZero() && !tx.CreatedAt.Before(f.CreatedBefore):
+	default:
+		return true
+	}
+	return false
+}
+
+func (m *dbMock) Spending(f whitelist.SpendingFilter) (whitelist.Spending, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := whitelist.Spending{Value: big.NewInt(0)}
+	for _, tx := range m.txs {
+		switch {
+		case tx.CreatedAt.Before(f.Since):
+		case f.From != "" && !strings.EqualFold(tx.From, f.From):
+		case f.To != "" && !sameAddress(tx.To, f.To):
+		case f.APIKey != "" && tx.APIKey != f.APIKey:
+		case f.ContractCreation && tx.To != "":
+		default:
+			addSpending(&s, *tx)
+		}
+	}
+	return s, nil
+}
+
+// sameAddress compares addresses whatever their spelling
+func sameAddress(a, b string) bool {
+	if common.IsHexAddress(a) && common.IsHexAddress(b) {
+		return common.HexToAddress(a) == common.HexToAddress(b)
+	}
+	return strings.EqualFold(a, b)
+}
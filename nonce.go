@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+)
+
+// nonceManager hands out the nonces of the signing account so that concurrent
+// requests never get the same one
+type nonceManager struct {
+	mu       sync.Mutex
+	client   Client
+	db       Recorder
+	owner    common.Address
+	next     uint64
+	released []uint64 // nonces given back after a failed broadcast, sorted
+}
+
+// newNonceManager seeds the next nonce from the pending nonce of the node
+func newNonceManager(ctx context.Context, client Client, db Recorder, owner common.Address) (*nonceManager, error) {
+	m := &nonceManager{
+		client: client,
+		db:     db,
+		owner:  owner,
+	}
+	err := m.Sync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Sync reloads the next nonce from the pending nonce of the node. Our pending
+// transactions right above it are skipped too when the node knows them, in
+// case its pending nonce lags behind. Those it doesn't know never reached it,
+// or were lost: their nonces are handed out again rather than left as gaps.
+// The next nonce never goes back though, since the nonces already handed out
+// may still be in flight, and the released nonces the node has seen used are
+// forgotten. Acquire waits for the resync.
+func (m *nonceManager) Sync(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	next, err := m.client.PendingNonceAt(ctx, m.owner)
+	if err != nil {
+		return err
+	}
+
+	txs, err := m.db.PendingTransactions()
+	if err != nil {
+		return err
+	}
+	hashes := map[uint64][]string{}
+	for _, tx := range txs {
+		if common.HexToAddress(tx.From) == m.owner && tx.Nonce >= next {
+			hashes[tx.Nonce] = append(hashes[tx.Nonce], tx.Hash)
+		}
+	}
+	for m.known(ctx, hashes[next]) {
+		next++
+	}
+
+	released := m.released[:0]
+	for _, n := range m.released {
+		if n >= next {
+			released = append(released, n)
+		}
+	}
+	m.released = released
+	if next > m.next {
+		m.next = next
+	}
+	return nil
+}
+
+// known tells if the node has one of the transactions
+func (m *nonceManager) known(ctx context.Context, hashes []string) bool {
+	for _, hash := range hashes {
+		if _, _, err := m.client.TransactionByHash(ctx, common.HexToHash(hash)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Peek returns the nonce Acquire would hand out, without reserving it
+func (m *nonceManager) Peek() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.released) > 0 {
+		return m.released[0]
+	}
+	return m.next
+}
+
+// Pending counts the nonces handed out and not released from mined, the
+// nonce of the account in the latest block. They are being broadcasted or
+// waiting to be mined.
+func (m *nonceManager) Pending(mined uint64) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.next <= mined {
+		return 0
+	}
+	pending := m.next - mined
+	for _, n := range m.released {
+		if n >= mined {
+			pending--
+		}
+	}
+	return pending
+}
+
+// Acquire returns the lowest nonce available. Released nonces are reused first
+// to fill the gaps left by failed transactions.
+func (m *nonceManager) Acquire() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.released) > 0 {
+		nonce := m.released[0]
+		m.released = m.released[1:]
+		return nonce
+	}
+
+	nonce := m.next
+	m.next++
+	return nonce
+}
+
+// AcquireReleased returns the lowest released nonce, if any. The nonces above
+// it are waiting in the queue of the node until it is used.
+func (m *nonceManager) AcquireReleased() (uint64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.released) == 0 {
+		return 0, false
+	}
+	nonce := m.released[0]
+	m.released = m.released[1:]
+	return nonce, true
+}
+
+// Release gives back a nonce that was acquired but never broadcasted
+func (m *nonceManager) Release(nonce uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if nonce >= m.next {
+		return
+	}
+	if nonce == m.next-1 {
+		m.next--
+		// Fold the released nonces that are now at the top
+		for len(m.released) > 0 && m.released[len(m.released)-1] == m.next-1 {
+			m.released = m.released[:len(m.released)-1]
+			m.next--
+		}
+		return
+	}
+	for _, n := range m.released {
+		if n == nonce {
+			return
+		}
+	}
+	m.released = append(m.released, nonce)
+	sort.Slice(m.released, func(i, j int) bool { return m.released[i] < m.released[j] })
+}
+
+// Failed must be called when a transaction using the nonce couldn't be
+// broadcasted. If the node reports the nonce as already used, something else
+// is sending from our account and the local state is reloaded, otherwise the
+// nonce is released.
+func (m *nonceManager) Failed(ctx context.Context, nonce uint64, sendErr error) error {
+	if sendErr != nil && strings.Contains(sendErr.Error(), core.ErrNonceTooLow.Error()) {
+		return m.Sync(ctx)
+	}
+	m.Release(nonce)
+	return nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// laggingClient reports the nonce of the latest block as the pending nonce,
+// like a node behind a load balancer that didn't see our transactions yet
+type laggingClient struct {
+	*backends.SimulatedBackend
+}
+
+func (c laggingClient) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return c.NonceAt(ctx, account, nil)
+}
+
+func Test_nonceManager(t *testing.T) {
+	ctx := context.Background()
+
+	ownerKey, _ := crypto.GenerateKey()
+	owner := bind.NewKeyedTransactor(ownerKey)
+
+	newClient := func() *backends.SimulatedBackend {
+		return backends.NewSimulatedBackend(core.GenesisAlloc{
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+		}, 4000000)
+	}
+
+	t.Run("Hands out unique nonces concurrently", func(t *testing.T) {
+		nonces, err := newNonceManager(ctx, newClient(), &dbMock{}, owner.From)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		seen := map[uint64]bool{}
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				n := nonces.Acquire()
+				mu.Lock()
+				defer mu.Unlock()
+				if seen[n] {
+					t.Errorf("nonce %v handed out twice", n)
+				}
+				seen[n] = true
+			}()
+		}
+		wg.Wait()
+
+		if got := nonces.Acquire(); got != 100 {
+			t.Errorf("next nonce = %v, want %v", got, 100)
+		}
+	})
+
+	t.Run("Keeps the nonces in flight during a resync", func(t *testing.T) {
+		// The node doesn't see the nonces handed out, which aren't broadcasted
+		nonces, err := newNonceManager(ctx, laggingClient{newClient()}, &dbMock{}, owner.From)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		seen := map[uint64]bool{}
+		for i := 0; i < 50; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				n := nonces.Acquire()
+				mu.Lock()
+				defer mu.Unlock()
+				if seen[n] {
+					t.Errorf("nonce %v handed out twice", n)
+				}
+				seen[n] = true
+			}()
+			go func() {
+				defer wg.Done()
+				if err := nonces.Sync(ctx); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if got := nonces.Acquire(); got != 50 {
+			t.Errorf("next nonce = %v, want %v", got, 50)
+		}
+	})
+
+	t.Run("Seeds from the node, skipping the transactions it knows", func(t *testing.T) {
+		client := newClient()
+		signer := types.HomesteadSigner{}
+		tx, _ := types.SignTx(types.NewTransaction(0, owner.From, big.NewInt(0), 21000, big.NewInt(1000000000), nil), signer, ownerKey)
+		err := client.SendTransaction(ctx, tx)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		from := owner.From.Hex()
+		other := common.HexToAddress("0x5597285BbE81BaF351e2C0884e9a5f4416958862").Hex()
+		db := &dbMock{txs: []*transaction{
+			{From: from, Nonce: 0, Hash: tx.Hash().Hex(), Status: txStatusPending},
+			{From: from, Nonce: 1, Hash: common.HexToHash("0x01").Hex(), Status: txStatusPending}, // never reached the node
+			{From: from, Nonce: 7, Hash: common.HexToHash("0x07").Hex(), Status: txStatusPending},
+			{From: other, Nonce: 20, Status: txStatusPending},
+		}}
+
+		for _, c := range []struct {
+			client Client
+			want   uint64
+		}{{client, 1}, {laggingClient{client}, 1}, {laggingClient{newClient()}, 0}} {
+			nonces, err := newNonceManager(ctx, c.client, db, owner.From)
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			if got := nonces.Acquire(); got != c.want {
+				t.Errorf("nonce = %v, want %v", got, c.want)
+			}
+		}
+	})
+
+}
+
+func Test_nonceRelease(t *testing.T) {
+	ctx := context.Background()
+
+	ownerKey, _ := crypto.GenerateKey()
+	owner := bind.NewKeyedTransactor(ownerKey)
+
+	newClient := func() *backends.SimulatedBackend {
+		return backends.NewSimulatedBackend(core.GenesisAlloc{
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+		}, 4000000)
+	}
+
+	t.Run("Reuses released nonces first", func(t *testing.T) {
+		nonces, err := newNonceManager(ctx, newClient(), &dbMock{}, owner.From)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		for i := 0; i < 4; i++ {
+			nonces.Acquire()
+		}
+		nonces.Release(1)
+		nonces.Release(2)
+
+		for _, want := range []uint64{1, 2, 4} {
+			if got := nonces.Acquire(); got != want {
+				t.Errorf("nonce = %v, want %v", got, want)
+			}
+		}
+	})
+
+	t.Run("Folds released nonces at the top", func(t *testing.T) {
+		nonces, err := newNonceManager(ctx, newClient(), &dbMock{}, owner.From)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		for i := 0; i < 3; i++ {
+			nonces.Acquire()
+		}
+		nonces.Release(1)
+		nonces.Release(2)
+
+		if got := nonces.Acquire(); got != 1 {
+			t.Errorf("nonce = %v, want %v", got, 1)
+		}
+		if got := nonces.Acquire(); got != 2 {
+			t.Errorf("nonce = %v, want %v", got, 2)
+		}
+	})
+
+	t.Run("Counts the pending nonces", func(t *testing.T) {
+		nonces, err := newNonceManager(ctx, newClient(), &dbMock{}, owner.From)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		for i := 0; i < 5; i++ {
+			nonces.Acquire()
+		}
+		nonces.Release(1)
+
+		for _, c := range []struct {
+			mined uint64
+			want  uint64
+		}{{0, 4}, {2, 3}, {5, 0}, {7, 0}} {
+			if got := nonces.Pending(c.mined); got != c.want {
+				t.Errorf("mined %v: pending = %v, want %v", c.mined, got, c.want)
+			}
+		}
+	})
+
+}
+
+func Test_nonceRecovery(t *testing.T) {
+	ctx := context.Background()
+
+	ownerKey, _ := crypto.GenerateKey()
+	owner := bind.NewKeyedTransactor(ownerKey)
+
+	newClient := func() *backends.SimulatedBackend {
+		return backends.NewSimulatedBackend(core.GenesisAlloc{
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+		}, 4000000)
+	}
+
+	t.Run("Resyncs when the nonce is too low", func(t *testing.T) {
+		client := newClient()
+		nonces, err := newNonceManager(ctx, client, &dbMock{}, owner.From)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		// Something else sends from the account
+		n := nonces.Acquire()
+		for i := uint64(0); i < 3; i++ {
+			tx, _ := types.SignTx(types.NewTransaction(i, owner.From, big.NewInt(0), 21000, big.NewInt(1000000000), nil), types.HomesteadSigner{}, ownerKey)
+			err := client.SendTransaction(ctx, tx)
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+		}
+		err = nonces.Failed(ctx, n, errors.New("nonce too low"))
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		if got := nonces.Acquire(); got != 3 {
+			t.Errorf("nonce = %v, want %v", got, 3)
+		}
+	})
+
+	t.Run("Fills the released gaps", func(t *testing.T) {
+		client := newClient()
+		accounts, err := newKeyring(ctx, client, &dbMock{}, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		a, signer := accounts[0], types.HomesteadSigner{}
+
+		n := a.nonces.Acquire()
+		tx, _ := types.SignTx(types.NewTransaction(n, owner.From, big.NewInt(0), 21000, big.NewInt(1000000000), nil), signer, ownerKey)
+		err = client.SendTransaction(ctx, tx)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		gap := a.nonces.Acquire()
+		a.nonces.Acquire() // still being sent
+		a.nonces.Release(gap)
+
+		a.fillGaps(ctx, client, signer)
+		client.Commit()
+
+		if got, _ := client.NonceAt(ctx, owner.From, nil); got != 2 {
+			t.Errorf("account nonce = %v, want %v", got, 2)
+		}
+		if got := a.nonces.Peek(); got != 3 {
+			t.Errorf("next nonce = %v, want %v", got, 3)
+		}
+	})
+
+	t.Run("Releases the nonce of a forbidden transaction", func(t *testing.T) {
+		client := newClient()
+		db := &dbMock{}
+		accounts, err := newKeyring(ctx, client, db, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		to := common.HexToAddress("0x5597285BbE81BaF351e2C0884e9a5f4416958862")
+		rules := `function validate(tx) return tx.value == "1" end`
+		signer := types.HomesteadSigner{}
+		h := txHandler(client, signer, nil, newTestValidator(t, rules), accounts, db)
+
+		for _, c := range []struct {
+			value string
+			code  int
+		}{{"2", 403}, {"1", 200}} {
+			p := sss.TxPayload{To: to.Hex(), Value: c.value, GasPrice: "1000000000"}
+			b := new(bytes.Buffer)
+			json.NewEncoder(b).Encode(p)
+			req, err := http.NewRequest("POST", "/tx", b)
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != c.code {
+				t.Errorf("response code = %v, want %v", rr.Code, c.code)
+				return
+			}
+		}
+		client.Commit()
+
+		if got, _ := client.NonceAt(ctx, owner.From, nil); got != 1 {
+			t.Errorf("account nonce = %v, want %v", got, 1)
+		}
+	})
+}
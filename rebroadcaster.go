@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/whitelist"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// bumpPolicy tells when and how much to raise the fees of a stuck
+// transaction. A zero AfterBlocks or After disables that trigger. MaxGasPrice
+// caps the gas price of legacy transactions and the fee cap of dynamic fee
+// ones.
+type bumpPolicy struct {
+	AfterBlocks uint64
+	After       time.Duration
+	Percent     int64
+	MaxGasPrice *big.Int
+}
+
+// rebroadcaster replaces the transactions that stay pending for too long with
+// the same transaction at a higher gas price
+type rebroadcaster struct {
+	client   Client
+	signer   types.Signer
+	rules    whitelist.Rules
+	keys     apiKeys // the rules of the API key that sent a transaction apply
+	accounts keyring
+	db       Recorder
+	policy   bumpPolicy
+}
+
+func newRebroadcaster(
+	client Client,
+	signer types.Signer,
+	rules whitelist.Rules,
+	keys apiKeys,
+	accounts keyring,
+	db Recorder,
+	policy bumpPolicy,
+) *rebroadcaster {
+	return &rebroadcaster{
+		client:   client,
+		signer:   signer,
+		rules:    rules,
+		keys:     keys,
+		accounts: accounts,
+		db:       db,
+		policy:   policy,
+	}
+}
+
+// Run checks the pending transactions every interval until the context is
+// cancelled
+func (rb *rebroadcaster) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := rb.Poll(ctx)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err.Error(),
+			}).Error("Error rebroadcasting transactions.")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll bumps the gas price of every stuck transaction once
+func (rb *rebroadcaster) Poll(ctx context.Context) error {
+	txs, err := rb.db.PendingTransactions()
+	if err != nil {
+		return err
+	}
+	if len(txs) == 0 {
+		return nil
+	}
+
+	head, err := rb.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return err
+	}
+	block := head.Number.Uint64()
+
+	for _, tx := range txs {
+		if tx.BroadcastBlock == 0 {
+			tx.BroadcastBlock = block
+			err := rb.db.SetBroadcastBlock(tx.ID, tx.Hash, block)
+			if err != nil {
+				return err
+			}
+		}
+
+		if !rb.stuck(tx, block) {
+			continue
+		}
+
+		fees, ok := rb.bump(recordedFees(tx))
+		if !ok {
+			log.WithFields(log.Fields{
+				"Nonce":    tx.Nonce,
+				"Hash":     tx.Hash,
+				"GasPrice": tx.GasPrice,
+				"MaxFee":   tx.MaxFeePerGas,
+			}).Warning("Transaction stuck at the maximum gas price")
+			continue
+		}
+
+		sender, err := rb.accounts.sender(tx)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Nonce": tx.Nonce,
+				"Hash":  tx.Hash,
+				"error": err.Error(),
+			}).Error("Error bumping gas price.")
+			continue
+		}
+
+		oldHash := tx.Hash
+		signedTx, err := replaceTransaction(ctx, rb.client, rb.signer, rb.keys.rulesOf(tx.APIKey, rb.rules), whitelist.Caller{ID: tx.APIKey, ClientCert: tx.ClientCert}, sender, rb.db, &tx, fees)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"From":     tx.From,
+				"Nonce":    tx.Nonce,
+				"Hash":     oldHash,
+				"GasPrice": bigString(fees.GasPrice),
+				"MaxFee":   bigString(fees.GasFeeCap),
+				"TipCap":   bigString(fees.GasTipCap),
+				"error":    err.Error(),
+			}).Error("Error bumping gas price.")
+			continue
+		}
+
+		err = rb.db.SetBroadcastBlock(tx.ID, tx.Hash, block)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Nonce": tx.Nonce,
+				"Hash":  tx.Hash,
+				"error": err.Error(),
+			}).Error("Error recording the broadcast block.")
+		}
+
+		log.WithFields(log.Fields{
+			"From":         tx.From,
+			"Nonce":        tx.Nonce,
+			"To":           tx.To,
+			"Value":        tx.Value,
+			"Gas":          tx.Gas,
+			"GasPrice":     bigString(fees.GasPrice),
+			"MaxFee":       bigString(fees.GasFeeCap),
+			"TipCap":       bigString(fees.GasTipCap),
+			"Hash":         signedTx.Hash().String(),
+			"ReplacedHash": oldHash,
+		}).Info("Successfully bumped gas price")
+	}
+
+	return nil
+}
+
+// stuck tells if a transaction waited long enough to be bumped
+func (rb *rebroadcaster) stuck(tx transaction, block uint64) bool {
+	if rb.policy.AfterBlocks > 0 && block >= tx.BroadcastBlock+rb.policy.AfterBlocks {
+		return true
+	}
+	broadcastAt := tx.BroadcastAt
+	if broadcastAt.IsZero() {
+		broadcastAt = tx.CreatedAt
+	}
+	return rb.policy.After > 0 && time.Since(broadcastAt) >= rb.policy.After
+}
+
+// bump returns the raised fees. It returns false if they can't be raised
+// anymore, or not enough under MaxGasPrice for the node to accept the
+// replacement.
+func (rb *rebroadcaster) bump(fees whitelist.Fees) (whitelist.Fees, bool) {
+	if !fees.Dynamic() {
+		price := rb.raise(fees.GasPrice, rb.policy.MaxGasPrice)
+		if !replaces(price, fees.GasPrice) {
+			return fees, false
+		}
+		fees.GasPrice = price
+		return fees, true
+	}
+
+	feeCap := rb.raise(fees.GasFeeCap, rb.policy.MaxGasPrice)
+	tipCap := rb.raise(fees.GasTipCap, feeCap)
+	if !replaces(feeCap, fees.GasFeeCap) || !replaces(tipCap, fees.GasTipCap) {
+		return fees, false
+	}
+	fees.GasFeeCap, fees.GasTipCap = feeCap, tipCap
+	return fees, true
+}
+
+// replaceBump is the raise in percent nodes require to replace a transaction
+// in their pool
+const replaceBump = 10
+
+// replaces tells if a fee is enough over the previous one for the node to
+// accept the replacement
+func replaces(fee, previous *big.Int) bool {
+	min := new(big.Int).Mul(previous, big.NewInt(100+replaceBump))
+	min.Div(min, big.NewInt(100))
+	return fee.Cmp(min) >= 0 && fee.Cmp(previous) > 0
+}
+
+// raise adds the policy percentage to an amount, at least 1 wei, without
+// going over max
+func (rb *rebroadcaster) raise(amount, max *big.Int) *big.Int {
+	raised := new(big.Int).Mul(amount, big.NewInt(100+rb.policy.Percent))
+	raised.Div(raised, big.NewInt(100))
+	if raised.Cmp(amount) <= 0 {
+		raised.Add(amount, big.NewInt(1))
+	}
+	if raised.Cmp(max) > 0 {
+		raised.Set(max)
+	}
+	return raised
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// sendStuck broadcasts a transaction then drops it from the pending block, as
+// if it was stuck in the pool
+func sendStuck(t *testing.T, ownerKey *ecdsa.PrivateKey, signer types.Signer, p sss.TxPayload) (*backends.SimulatedBackend, *dbMock, keyring, string) {
+	client := backends.NewSimulatedBackend(core.GenesisAlloc{
+		crypto.PubkeyToAddress(ownerKey.PublicKey): core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+	}, 4000000)
+	db := &dbMock{}
+	accounts, err := newKeyring(context.Background(), client, db, ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.To = "0x5597285BbE81BaF351e2C0884e9a5f4416958862"
+	p.Value = "10000000000"
+	b := new(bytes.Buffer)
+	json.NewEncoder(b).Encode(p)
+	req, err := http.NewRequest("POST", "/v1/proxy/transactions", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	txHandler(client, signer, nil, newTestValidator(t, `function validate(tx) return true end`), accounts, db).ServeHTTP(rr, req)
+	client.Rollback()
+	if rr.Code != 200 {
+		t.Fatalf("response code = %v, want %v", rr.Code, 200)
+	}
+	return client, db, accounts, rr.Body.String()
+}
+
+func Test_rebroadcaster(t *testing.T) {
+	ctx := context.Background()
+	ownerKey, _ := crypto.GenerateKey()
+	signer := types.NewLondonSigner(big.NewInt(1337))
+	allowAll := `function validate(tx) return true end`
+	send := func(t *testing.T, p sss.TxPayload) (*backends.SimulatedBackend, *dbMock, keyring, string) {
+		return sendStuck(t, ownerKey, signer, p)
+	}
+
+	t.Run("Bumps a transaction pending for too long", func(t *testing.T) {
+		client, db, accounts, hash := send(t, sss.TxPayload{GasPrice: "10000000000"})
+
+		rb := newRebroadcaster(client, signer, newTestValidator(t, allowAll), nil, accounts, db, bumpPolicy{
+			After:       time.Nanosecond,
+			Percent:     20,
+			MaxGasPrice: big.NewInt(100000000000),
+		})
+		err := rb.Poll(ctx)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		client.Commit()
+
+		got := db.txs[0]
+		if got.GasPrice != "12000000000" {
+			t.Errorf("gas price = %v, want %v", got.GasPrice, "12000000000")
+		}
+		if got.Hash == hash {
+			t.Errorf("hash = %v, want a replacement", got.Hash)
+		}
+		if previous, _ := db.PreviousHashes(got.ID); !reflect.DeepEqual(previous, []string{hash}) {
+			t.Errorf("previous hashes = %v, want %v", previous, []string{hash})
+		}
+
+		receipt, _ := client.TransactionReceipt(ctx, common.HexToHash(got.Hash))
+		if receipt == nil {
+			t.Errorf("transaction receipt = %v", nil)
+		}
+	})
+
+	t.Run("Bumps after a number of blocks", func(t *testing.T) {
+		client, db, accounts, hash := send(t, sss.TxPayload{GasPrice: "10000000000"})
+		client.Commit()
+
+		rb := newRebroadcaster(client, signer, newTestValidator(t, allowAll), nil, accounts, db, bumpPolicy{
+			AfterBlocks: 3,
+			Percent:     10,
+			MaxGasPrice: big.NewInt(100000000000),
+		})
+
+		for block := 1; block < 4; block++ {
+			err := rb.Poll(ctx)
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			if db.txs[0].Hash != hash {
+				t.Errorf("block %v: hash = %v, want %v", block, db.txs[0].Hash, hash)
+			}
+			client.Commit()
+		}
+
+		err := rb.Poll(ctx)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if db.txs[0].GasPrice != "11000000000" {
+			t.Errorf("gas price = %v, want %v", db.txs[0].GasPrice, "11000000000")
+		}
+		if db.txs[0].BroadcastBlock != 4 {
+			t.Errorf("broadcast block = %v, want %v", db.txs[0].BroadcastBlock, 4)
+		}
+	})
+
+	t.Run("Bumps the fee caps of a dynamic fee transaction", func(t *testing.T) {
+		client, db, accounts, _ := send(t, sss.TxPayload{MaxFeePerGas: "10000000000", MaxPriorityFeePerGas: "1000000000"})
+
+		rb := newRebroadcaster(client, signer, newTestValidator(t, allowAll), nil, accounts, db, bumpPolicy{
+			After:       time.Nanosecond,
+			Percent:     10,
+			MaxGasPrice: big.NewInt(100000000000),
+		})
+		err := rb.Poll(ctx)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		client.Commit()
+
+		got := db.txs[0]
+		if got.MaxFeePerGas != "11000000000" || got.MaxPriorityFeePerGas != "1100000000" {
+			t.Errorf("fee caps = %v %v, want %v %v", got.MaxFeePerGas, got.MaxPriorityFeePerGas, "11000000000", "1100000000")
+		}
+
+		tx, _, err := client.TransactionByHash(ctx, common.HexToHash(got.Hash))
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if tx.Type() != types.DynamicFeeTxType {
+			t.Errorf("transaction type = %v, want %v", tx.Type(), types.DynamicFeeTxType)
+		}
+	})
+
+}
+
+func Test_rebroadcasterLimits(t *testing.T) {
+	ctx := context.Background()
+	ownerKey, _ := crypto.GenerateKey()
+	signer := types.NewLondonSigner(big.NewInt(1337))
+	allowAll := `function validate(tx) return true end`
+	send := func(t *testing.T, p sss.TxPayload) (*backends.SimulatedBackend, *dbMock, keyring, string) {
+		return sendStuck(t, ownerKey, signer, p)
+	}
+
+	t.Run("Caps the gas price", func(t *testing.T) {
+		client, db, accounts, _ := send(t, sss.TxPayload{GasPrice: "10000000000"})
+
+		rb := newRebroadcaster(client, signer, newTestValidator(t, allowAll), nil, accounts, db, bumpPolicy{
+			After:       time.Nanosecond,
+			Percent:     50,
+			MaxGasPrice: big.NewInt(13000000000),
+		})
+		for i := 0; i < 2; i++ {
+			err := rb.Poll(ctx)
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			client.Rollback()
+		}
+
+		if db.txs[0].GasPrice != "13000000000" {
+			t.Errorf("gas price = %v, want %v", db.txs[0].GasPrice, "13000000000")
+		}
+		if previous, _ := db.PreviousHashes(db.txs[0].ID); len(previous) != 1 {
+			t.Errorf("previous hashes = %v, want %v", len(previous), 1)
+		}
+	})
+
+	t.Run("Doesn't bump by less than nodes accept", func(t *testing.T) {
+		client, db, accounts, hash := send(t, sss.TxPayload{GasPrice: "10000000000"})
+
+		rb := newRebroadcaster(client, signer, newTestValidator(t, allowAll), nil, accounts, db, bumpPolicy{
+			After:       time.Nanosecond,
+			Percent:     50,
+			MaxGasPrice: big.NewInt(10500000000),
+		})
+		err := rb.Poll(ctx)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		if db.txs[0].Hash != hash || db.txs[0].GasPrice != "10000000000" {
+			t.Errorf("transaction = %v %v, want %v %v", db.txs[0].Hash, db.txs[0].GasPrice, hash, "10000000000")
+		}
+		if previous, _ := db.PreviousHashes(db.txs[0].ID); len(previous) != 0 {
+			t.Errorf("previous hashes = %v, want %v", len(previous), 0)
+		}
+	})
+
+	t.Run("Leaves the status the receipt watcher set", func(t *testing.T) {
+		client, db, accounts, _ := send(t, sss.TxPayload{GasPrice: "10000000000"})
+		client.Commit()
+
+		rb := newRebroadcaster(client, signer, newTestValidator(t, allowAll), nil, accounts, minedMeanwhile{db}, bumpPolicy{
+			After:       time.Hour,
+			Percent:     10,
+			MaxGasPrice: big.NewInt(100000000000),
+		})
+		err := rb.Poll(ctx)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		if db.txs[0].Status != txStatusMined {
+			t.Errorf("status = %v, want %v", db.txs[0].Status, txStatusMined)
+		}
+		if db.txs[0].BroadcastBlock == 0 {
+			t.Errorf("broadcast block = %v, want the current block", db.txs[0].BroadcastBlock)
+		}
+	})
+
+	t.Run("Doesn't bump a forbidden transaction", func(t *testing.T) {
+		client, db, accounts, hash := send(t, sss.TxPayload{GasPrice: "10000000000"})
+
+		rb := newRebroadcaster(client, signer, newTestValidator(t, `function validate(tx) return false end`), nil, accounts, db, bumpPolicy{
+			After:       time.Nanosecond,
+			Percent:     10,
+			MaxGasPrice: big.NewInt(100000000000),
+		})
+		err := rb.Poll(ctx)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		if db.txs[0].Hash != hash || db.txs[0].GasPrice != "10000000000" {
+			t.Errorf("transaction = %v %v, want %v %v", db.txs[0].Hash, db.txs[0].GasPrice, hash, "10000000000")
+		}
+	})
+}
+
+// minedMeanwhile marks the transactions mined right after they are read, as
+// the receipt watcher would while the rebroadcaster polls
+type minedMeanwhile struct {
+	*dbMock
+}
+
+func (m minedMeanwhile) PendingTransactions() ([]transaction, error) {
+	txs, err := m.dbMock.PendingTransactions()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, tx := range m.txs {
+		tx.Status = txStatusMined
+	}
+	return txs, err
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/whitelist"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// receiptWatcher polls the receipts of the pending transactions and records
+// whether they were mined, reverted or dropped
+type receiptWatcher struct {
+	client Client
+	db     Recorder
+}
+
+func newReceiptWatcher(client Client, db Recorder) *receiptWatcher {
+	return &receiptWatcher{
+		client: client,
+		db:     db,
+	}
+}
+
+// Run polls every interval until the context is cancelled
+func (rw *receiptWatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := rw.Poll(ctx)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err.Error(),
+			}).Error("Error polling receipts.")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll checks the receipt of every pending transaction once
+func (rw *receiptWatcher) Poll(ctx context.Context) error {
+	txs, err := rw.db.PendingTransactions()
+	if err != nil {
+		return err
+	}
+	if len(txs) == 0 {
+		return nil
+	}
+
+	// Read the nonces of the senders before the receipts, so a transaction
+	// mined in between can't be mistaken for a dropped one
+	accountNonces := map[string]uint64{}
+	for _, tx := range txs {
+		if _, ok := accountNonces[tx.From]; ok {
+			continue
+		}
+		accountNonces[tx.From], err = rw.client.NonceAt(ctx, common.HexToAddress(tx.From), nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, tx := range txs {
+		hash := tx.Hash
+		receipt, err := rw.client.TransactionReceipt(ctx, common.HexToHash(tx.Hash))
+		if err != nil && err != ethereum.NotFound {
+			log.WithFields(log.Fields{
+				"Nonce": tx.Nonce,
+				"Hash":  tx.Hash,
+				"error": err.Error(),
+			}).Error("Error getting transaction receipt.")
+			continue
+		}
+
+		if receipt == nil && tx.Nonce < accountNonces[tx.From] {
+			// A replaced transaction may have been mined instead
+			receipt, err = rw.previousReceipt(ctx, &tx)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"Nonce": tx.Nonce,
+					"Hash":  tx.Hash,
+					"error": err.Error(),
+				}).Error("Error getting the receipts of the replaced transactions.")
+				continue
+			}
+		}
+
+		if receipt == nil {
+			if tx.Nonce < accountNonces[tx.From] {
+				tx.Status = txStatusDropped
+				err = rw.db.RecordOutcome(tx, hash)
+				if err != nil {
+					log.WithFields(log.Fields{
+						"Nonce": tx.Nonce,
+						"Hash":  tx.Hash,
+						"error": err.Error(),
+					}).Error("Error recording the transaction status.")
+					continue
+				}
+				log.WithFields(log.Fields{
+					"Nonce": tx.Nonce,
+					"Hash":  tx.Hash,
+				}).Warning("Transaction dropped")
+			}
+			continue
+		}
+
+		var baseFee *big.Int
+		if tx.Type == types.DynamicFeeTxType {
+			head, err := rw.client.HeaderByNumber(ctx, receipt.BlockNumber)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"Nonce": tx.Nonce,
+					"Hash":  tx.Hash,
+					"error": err.Error(),
+				}).Error("Error getting block header.")
+				continue
+			}
+			baseFee = head.BaseFee
+		}
+
+		recordReceipt(&tx, receipt, baseFee)
+		err = rw.db.RecordOutcome(tx, hash)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Nonce": tx.Nonce,
+				"Hash":  tx.Hash,
+				"error": err.Error(),
+			}).Error("Error recording the transaction receipt.")
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"Nonce":       tx.Nonce,
+			"Hash":        tx.Hash,
+			"Status":      tx.Status,
+			"BlockNumber": tx.BlockNumber,
+			"GasUsed":     tx.GasUsed,
+			"Fee":         tx.Fee,
+		}).Info("Transaction mined")
+	}
+
+	return nil
+}
+
+// previousReceipt looks for the receipt of a transaction tx replaced. When one
+// was mined, its hash and fees become those of tx, and the hash of tx becomes
+// a previous one.
+func (rw *receiptWatcher) previousReceipt(ctx context.Context, tx *transaction) (*types.Receipt, error) {
+	hashes, err := rw.db.PreviousHashes(tx.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range hashes {
+		receipt, err := rw.client.TransactionReceipt(ctx, common.HexToHash(hash))
+		if err == ethereum.NotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		mined, _, err := rw.client.TransactionByHash(ctx, common.HexToHash(hash))
+		if err != nil {
+			return nil, err
+		}
+		err = rw.db.ReplaceHash(tx.ID, hash, tx.Hash)
+		if err != nil {
+			return nil, err
+		}
+		log.WithFields(log.Fields{
+			"Nonce":    tx.Nonce,
+			"Hash":     hash,
+			"Replaced": tx.Hash,
+		}).Warning("Replaced transaction mined")
+
+		tx.Hash = hash
+		if mined.Type() == types.DynamicFeeTxType {
+			tx.GasPrice = ""
+			tx.MaxFeePerGas = mined.GasFeeCap().String()
+			tx.MaxPriorityFeePerGas = mined.GasTipCap().String()
+		} else {
+			tx.GasPrice = mined.GasPrice().String()
+		}
+		return receipt, nil
+	}
+	return nil, nil
+}
+
+// recordReceipt copies the outcome of a mined transaction to its row. The base
+// fee of the block is needed to compute the fee of dynamic fee transactions.
+func recordReceipt(tx *transaction, receipt *types.Receipt, baseFee *big.Int) {
+	tx.Status = txStatusMined
+	if receipt.Status == types.ReceiptStatusFailed {
+		tx.Status = txStatusFailed
+	}
+	if receipt.BlockNumber != nil {
+		tx.BlockNumber = receipt.BlockNumber.Uint64()
+	}
+	tx.BlockHash = receipt.BlockHash.String()
+	tx.GasUsed = receipt.GasUsed
+
+	gp := effectiveGasPrice(recordedFees(*tx), baseFee)
+	if gp != nil {
+		tx.Fee = gp.Mul(gp, new(big.Int).SetUint64(receipt.GasUsed)).String()
+	}
+}
+
+// effectiveGasPrice is the price per gas actually paid: the gas price of a
+// legacy transaction, or the base fee plus the tip within the fee cap
+func effectiveGasPrice(fees whitelist.Fees, baseFee *big.Int) *big.Int {
+	if !fees.Dynamic() {
+		return fees.GasPrice
+	}
+	if baseFee == nil || fees.GasFeeCap == nil || fees.GasTipCap == nil {
+		return nil
+	}
+	gp := new(big.Int).Add(baseFee, fees.GasTipCap)
+	if gp.Cmp(fees.GasFeeCap) > 0 {
+		gp.Set(fees.GasFeeCap)
+	}
+	return gp
+}
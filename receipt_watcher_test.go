@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// receiptFixture is a simulated chain with a funded owner, on which the tests
+// send transactions through the handler
+type receiptFixture struct {
+	client *backends.SimulatedBackend
+	db     *dbMock
+	h      http.HandlerFunc
+	to     common.Address
+}
+
+func newReceiptFixture(t *testing.T) *receiptFixture {
+	ownerKey, _ := crypto.GenerateKey()
+	owner := bind.NewKeyedTransactor(ownerKey)
+
+	testerKey, _ := crypto.GenerateKey()
+	tester := bind.NewKeyedTransactor(testerKey)
+
+	client := backends.NewSimulatedBackend(core.GenesisAlloc{
+		owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+	}, 4000000)
+	db := &dbMock{}
+	accounts, err := newKeyring(context.Background(), client, db, ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := `function validate(tx) return true end`
+	h := txHandler(client, types.HomesteadSigner{}, nil, newTestValidator(t, rules), accounts, db)
+	return &receiptFixture{client: client, db: db, h: h, to: tester.From}
+}
+
+func (f *receiptFixture) send(t *testing.T) string {
+	p := sss.TxPayload{To: f.to.Hex(), Value: "10000000000", GasPrice: "2000000000"}
+	b := new(bytes.Buffer)
+	json.NewEncoder(b).Encode(p)
+	req, err := http.NewRequest("POST", "/tx", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	f.h.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatalf("response code = %v, want %v", rr.Code, 200)
+	}
+	return rr.Body.String()
+}
+
+func Test_receiptWatcher(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Records the receipt of a mined transaction", func(t *testing.T) {
+		f := newReceiptFixture(t)
+		client, db := f.client, f.db
+
+		hash := f.send(t)
+		rw := newReceiptWatcher(client, db)
+
+		err := rw.Poll(ctx)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if db.txs[0].Status != txStatusPending {
+			t.Errorf("status = %v, want %v", db.txs[0].Status, txStatusPending)
+		}
+
+		client.Commit()
+		err = rw.Poll(ctx)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		receipt, _ := client.TransactionReceipt(ctx, common.HexToHash(hash))
+		got := db.txs[0]
+		if got.Status != txStatusMined {
+			t.Errorf("status = %v, want %v", got.Status, txStatusMined)
+		}
+		if got.BlockNumber != 1 {
+			t.Errorf("block number = %v, want %v", got.BlockNumber, 1)
+		}
+		if got.BlockHash != receipt.BlockHash.String() {
+			t.Errorf("block hash = %v, want %v", got.BlockHash, receipt.BlockHash.String())
+		}
+		if got.GasUsed != 21000 {
+			t.Errorf("gas used = %v, want %v", got.GasUsed, 21000)
+		}
+		if got.Fee != "42000000000000" {
+			t.Errorf("fee = %v, want %v", got.Fee, "42000000000000")
+		}
+	})
+
+	t.Run("Marks a transaction whose nonce was used as dropped", func(t *testing.T) {
+		f := newReceiptFixture(t)
+		client, db := f.client, f.db
+
+		f.send(t)
+		client.Commit()
+		db.txs[0].Hash = common.Hash{}.String()
+
+		err := newReceiptWatcher(client, db).Poll(ctx)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		if db.txs[0].Status != txStatusDropped {
+			t.Errorf("status = %v, want %v", db.txs[0].Status, txStatusDropped)
+		}
+	})
+
+}
+
+func Test_receiptWatcherReplacements(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Records a replaced transaction mined instead", func(t *testing.T) {
+		f := newReceiptFixture(t)
+		client, db := f.client, f.db
+
+		hash := f.send(t)
+		client.Commit()
+
+		// A bump that lost the race
+		bump := common.HexToHash("0x01").String()
+		db.Create(&txHash{TransactionID: db.txs[0].ID, Hash: hash})
+		db.txs[0].Hash, db.txs[0].GasPrice = bump, "2400000000"
+
+		err := newReceiptWatcher(client, db).Poll(ctx)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		got := db.txs[0]
+		if got.Status != txStatusMined || got.Hash != hash {
+			t.Errorf("status = %v, hash = %v, want %v, %v", got.Status, got.Hash, txStatusMined, hash)
+		}
+		if got.GasPrice != "2000000000" || got.Fee != "42000000000000" {
+			t.Errorf("gas price = %v, fee = %v, want %v, %v", got.GasPrice, got.Fee, "2000000000", "42000000000000")
+		}
+		if previous, _ := db.PreviousHashes(got.ID); !reflect.DeepEqual(previous, []string{bump}) {
+			t.Errorf("previous hashes = %v, want %v", previous, []string{bump})
+		}
+	})
+
+	t.Run("Leaves a transaction replaced during the poll", func(t *testing.T) {
+		f := newReceiptFixture(t)
+		client, db := f.client, f.db
+
+		f.send(t)
+		client.Commit()
+
+		bump := common.HexToHash("0x01").String()
+		err := newReceiptWatcher(client, replacedMeanwhile{db, bump}).Poll(ctx)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		got := db.txs[0]
+		if got.Status != txStatusPending || got.Hash != bump || got.GasPrice != "2400000000" {
+			t.Errorf("transaction = %v %v %v, want %v %v %v", got.Status, got.Hash, got.GasPrice, txStatusPending, bump, "2400000000")
+		}
+	})
+
+}
+
+func Test_recordReceipt(t *testing.T) {
+	t.Run("Marks a reverted transaction as failed", func(t *testing.T) {
+		tx := transaction{GasPrice: "3"}
+		recordReceipt(&tx, &types.Receipt{
+			Status:      types.ReceiptStatusFailed,
+			GasUsed:     30000,
+			BlockNumber: big.NewInt(12),
+		}, nil)
+
+		if tx.Status != txStatusFailed {
+			t.Errorf("status = %v, want %v", tx.Status, txStatusFailed)
+		}
+		if tx.BlockNumber != 12 {
+			t.Errorf("block number = %v, want %v", tx.BlockNumber, 12)
+		}
+		if tx.Fee != "90000" {
+			t.Errorf("fee = %v, want %v", tx.Fee, "90000")
+		}
+	})
+}
+
+// replacedMeanwhile replaces the transactions right after they are read, as a
+// retry would while the receipt watcher polls
+type replacedMeanwhile struct {
+	*dbMock
+	hash string
+}
+
+func (m replacedMeanwhile) PendingTransactions() ([]transaction, error) {
+	txs, err := m.dbMock.PendingTransactions()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, tx := range m.txs {
+		tx.Hash, tx.GasPrice = m.hash, "2400000000"
+	}
+	return txs, err
+}
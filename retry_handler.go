@@ -2,13 +2,15 @@ package main
 
 import (
 	"context"
-	"crypto/ecdsa"
 	"encoding/json"
+	"errors"
 	"math/big"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+	"github.com/WeTrustPlatform/secure-signing-serv/whitelist"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 
@@ -18,9 +20,9 @@ import (
 func retryHandler(
 	client Client,
 	signer types.Signer,
-	rules string,
-	owner common.Address,
-	key *ecdsa.PrivateKey,
+	rules whitelist.Rules,
+	keys apiKeys,
+	accounts keyring,
 	db Recorder,
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -31,7 +33,11 @@ func retryHandler(
 			return
 		}
 
-		hash := strings.TrimPrefix(r.URL.Path, "/v1/proxy/transactions/")
+		hash, ok := hashFromPath(r.URL.Path)
+		if !ok {
+			http.Error(w, "invalid transaction hash", http.StatusBadRequest)
+			return
+		}
 
 		decoder := json.NewDecoder(r.Body)
 		var p sss.RetryPayload
@@ -41,84 +47,190 @@ func retryHandler(
 			return
 		}
 
-		if len(p) != 1 || p[0].Op != "replace" || p[0].Path != "/gasPrice" {
-			http.Error(w, "only replacing gasPrice is supported", http.StatusBadRequest)
+		oldTx, found, err := db.TransactionByHash(hash)
+		if err != nil {
+			http.Error(w, "error reading transaction: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		gp, ok := big.NewInt(0).SetString(p[0].Value, 10)
-		if !ok {
-			http.Error(w, "couldn't convert gasPrice to big.Int", http.StatusBadRequest)
+		if !found || !mayAccess(r, oldTx) {
+			http.Error(w, "transaction not found", http.StatusNotFound)
 			return
 		}
 
-		oldTx := transaction{}
-		db.First(&oldTx, "hash = ?", hash)
-
-		var tx *types.Transaction
-		if oldTx.To != "" {
-			value, _ := big.NewInt(0).SetString(oldTx.Value, 10)
-			tx = types.NewTransaction(
-				oldTx.Nonce,
-				common.HexToAddress(oldTx.To),
-				value,
-				oldTx.Gas,
-				gp,
-				common.Hex2Bytes(oldTx.Data),
-			)
-		} else {
-			tx = types.NewContractCreation(
-				oldTx.Nonce,
-				big.NewInt(0),
-				oldTx.Gas,
-				gp,
-				common.Hex2Bytes(oldTx.Data),
-			)
-		}
-
-		valid, err := validate(rules, tx)
+		sender, err := accounts.sender(oldTx)
 		if err != nil {
-			http.Error(w, "error validating transaction: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusConflict)
 			return
 		}
-		if !valid {
-			log.WithFields(log.Fields{
-				"Nonce":    oldTx.Nonce,
-				"To":       oldTx.To,
-				"Value":    oldTx.Value,
-				"Gas":      oldTx.Gas,
-				"GasPrice": gp.String(),
-				"Hash":     tx.Hash().String(),
-			}).Warning("Forbidden transaction")
-			http.Error(w, "forbidden transaction", http.StatusForbidden)
+		if k := callerOf(r); k != nil && !k.mayUse(sender.address) {
+			http.Error(w, "account not allowed "+oldTx.From, http.StatusForbidden)
 			return
 		}
 
-		signedTx, err := types.SignTx(tx, signer, key)
+		fees, err := patchFees(p, recordedFees(oldTx))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		err = client.SendTransaction(ctx, signedTx)
+		// Validate with the rules of the key that sent the transaction, like the
+		// rebroadcaster does
+		owner := whitelist.Caller{ID: oldTx.APIKey, ClientCert: oldTx.ClientCert}
+		signedTx, err := replaceTransaction(ctx, client, signer, keys.rulesOf(oldTx.APIKey, rules), owner, sender, db, &oldTx, fees)
+		if e, ok := err.(errForbidden); ok {
+			forbidden(w, e.decision)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		oldTx.Hash = signedTx.Hash().String()
-		oldTx.GasPrice = gp.String()
-		db.Save(oldTx)
-
 		log.WithFields(log.Fields{
+			"From":     oldTx.From,
 			"Nonce":    oldTx.Nonce,
 			"To":       oldTx.To,
 			"Value":    oldTx.Value,
 			"Gas":      oldTx.Gas,
-			"GasPrice": gp.String(),
+			"GasPrice": bigString(fees.GasPrice),
+			"MaxFee":   bigString(fees.GasFeeCap),
+			"TipCap":   bigString(fees.GasTipCap),
 			"Hash":     signedTx.Hash().String(),
 		}).Info("Successfully retried transaction")
 
 		w.Write([]byte(signedTx.Hash().String()))
 	}
 }
+
+// patchFees applies the replace operations of a PATCH to the fees of a
+// transaction. Legacy transactions only have a gasPrice, dynamic fee ones only
+// have maxFeePerGas and maxPriorityFeePerGas.
+func patchFees(p sss.RetryPayload, fees whitelist.Fees) (whitelist.Fees, error) {
+	if len(p) == 0 {
+		return fees, errors.New("no operation to apply")
+	}
+
+	for _, op := range p {
+		if op.Op != "replace" {
+			return fees, errors.New("only replace operations are supported")
+		}
+
+		v, ok := big.NewInt(0).SetString(op.Value, 10)
+		if !ok {
+			return fees, errors.New("couldn't convert " + strings.TrimPrefix(op.Path, "/") + " to big.Int")
+		}
+
+		switch {
+		case op.Path == "/gasPrice" && !fees.Dynamic():
+			fees.GasPrice = v
+		case op.Path == "/maxFeePerGas" && fees.Dynamic():
+			fees.GasFeeCap = v
+		case op.Path == "/maxPriorityFeePerGas" && fees.Dynamic():
+			fees.GasTipCap = v
+		case fees.Dynamic():
+			return fees, errors.New("only replacing maxFeePerGas and maxPriorityFeePerGas is supported")
+		default:
+			return fees, errors.New("only replacing gasPrice is supported")
+		}
+	}
+
+	if fees.Dynamic() && fees.GasTipCap.Cmp(fees.GasFeeCap) > 0 {
+		return fees, errors.New("maxPriorityFeePerGas can't be higher than maxFeePerGas")
+	}
+	return fees, nil
+}
+
+// errForbidden is returned when the rules refuse a transaction
+type errForbidden struct {
+	decision whitelist.Decision
+}
+
+func (e errForbidden) Error() string {
+	if e.decision.Message != "" {
+		return "forbidden transaction: " + e.decision.Message
+	}
+	return "forbidden transaction"
+}
+
+// replaceTransaction re-signs a recorded transaction with the account that
+// sent it, at the same nonce with new fees, broadcasts it and records the
+// replacement. The new transaction has to pass the rules of the account and
+// the given ones again.
+func replaceTransaction(
+	ctx context.Context,
+	client Client,
+	signer types.Signer,
+	rules whitelist.Rules,
+	caller whitelist.Caller,
+	sender *account,
+	db Recorder,
+	oldTx *transaction,
+	fees whitelist.Fees,
+) (*types.Transaction, error) {
+	var to *common.Address
+	if oldTx.To != "" {
+		address := common.HexToAddress(oldTx.To)
+		to = &address
+	}
+	value, _ := big.NewInt(0).SetString(oldTx.Value, 10)
+	tx := whitelist.NewTransaction(
+		signer.ChainID(),
+		oldTx.Nonce,
+		to,
+		value,
+		oldTx.Gas,
+		fees,
+		common.Hex2Bytes(oldTx.Data),
+	)
+
+	decision, err := sender.scope(rules).Validate(tx, sender.address, signer.ChainID(), caller)
+	if err != nil {
+		return nil, errors.New("error validating transaction: " + err.Error())
+	}
+	if !decision.Allowed {
+		log.WithFields(log.Fields{
+			"From":     oldTx.From,
+			"Nonce":    oldTx.Nonce,
+			"To":       oldTx.To,
+			"Value":    oldTx.Value,
+			"Gas":      oldTx.Gas,
+			"GasPrice": bigString(fees.GasPrice),
+			"MaxFee":   bigString(fees.GasFeeCap),
+			"TipCap":   bigString(fees.GasTipCap),
+			"Hash":     tx.Hash().String(),
+			"Code":     decision.Code,
+			"Message":  decision.Message,
+		}).Warning("Forbidden transaction")
+		return nil, errForbidden{decision}
+	}
+
+	signedTx, err := sender.signer.SignTx(ctx, tx, signer.ChainID())
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.SendTransaction(ctx, signedTx)
+	if err != nil {
+		return nil, err
+	}
+
+	replaced := oldTx.Hash
+	db.Create(&txHash{TransactionID: oldTx.ID, Hash: replaced})
+	oldTx.Hash = signedTx.Hash().String()
+	oldTx.GasPrice = bigString(fees.GasPrice)
+	oldTx.MaxFeePerGas = bigString(fees.GasFeeCap)
+	oldTx.MaxPriorityFeePerGas = bigString(fees.GasTipCap)
+	oldTx.Status = txStatusPending
+	oldTx.BroadcastAt = time.Now()
+	oldTx.BroadcastBlock = 0
+	err = db.RecordReplacement(*oldTx, replaced)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Nonce": oldTx.Nonce,
+			"Hash":  oldTx.Hash,
+			"error": err.Error(),
+		}).Error("Error recording the replacement.")
+	}
+
+	return signedTx, nil
+}
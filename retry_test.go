@@ -32,10 +32,15 @@ func Test_retry(t *testing.T) {
 
 	t.Run("Can replace a transaction", func(t *testing.T) {
 		client := backends.NewSimulatedBackend(core.GenesisAlloc{
-			owner.From: core.GenesisAccount{Balance: big.NewInt(50000000000)},
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
 		}, 4000000)
+		accounts, err := newKeyring(context.Background(), client, db, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
 
-		p := sss.TxPayload{To: tester.From.Hex(), Value: "10000000000", GasPrice: "1"}
+		p := sss.TxPayload{To: tester.From.Hex(), Value: "10000000000", GasPrice: "1000000000"}
 		b := new(bytes.Buffer)
 		json.NewEncoder(b).Encode(p)
 		req, err := http.NewRequest("POST", "/v1/proxy/transactions", b)
@@ -44,7 +49,7 @@ func Test_retry(t *testing.T) {
 			return
 		}
 
-		h := txHandler(client, signer, rules, owner.From, ownerKey, db)
+		h := txHandler(client, signer, nil, newTestValidator(t, rules), accounts, db)
 		rr := httptest.NewRecorder()
 		h.ServeHTTP(rr, req)
 		client.Rollback()
@@ -63,7 +68,7 @@ func Test_retry(t *testing.T) {
 
 		hash := rr.Body.String()
 		rp := sss.RetryPayload{
-			sss.PatchOperation{Op: "replace", Path: "/gasPrice", Value: "2"},
+			sss.PatchOperation{Op: "replace", Path: "/gasPrice", Value: "2000000000"},
 		}
 		rb := new(bytes.Buffer)
 		json.NewEncoder(rb).Encode(rp)
@@ -73,7 +78,7 @@ func Test_retry(t *testing.T) {
 			return
 		}
 
-		rh := retryHandler(client, signer, rules, owner.From, ownerKey, db)
+		rh := retryHandler(client, signer, newTestValidator(t, rules), nil, accounts, db)
 		rrr := httptest.NewRecorder()
 		rh.ServeHTTP(rrr, rreq)
 		client.Commit()
@@ -88,4 +93,129 @@ func Test_retry(t *testing.T) {
 			return
 		}
 	})
+
+	t.Run("Can replace a dynamic fee transaction", func(t *testing.T) {
+		client := backends.NewSimulatedBackend(core.GenesisAlloc{
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+		}, 4000000)
+		db := &dbMock{}
+		accounts, err := newKeyring(context.Background(), client, db, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		londonSigner := types.NewLondonSigner(big.NewInt(1337))
+
+		p := sss.TxPayload{To: tester.From.Hex(), Value: "10000000000", MaxFeePerGas: "3000000000", MaxPriorityFeePerGas: "1"}
+		b := new(bytes.Buffer)
+		json.NewEncoder(b).Encode(p)
+		req, err := http.NewRequest("POST", "/v1/proxy/transactions", b)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		rr := httptest.NewRecorder()
+		txHandler(client, londonSigner, nil, newTestValidator(t, rules), accounts, db).ServeHTTP(rr, req)
+		client.Rollback()
+
+		for _, c := range []struct {
+			path string
+			code int
+		}{{"/gasPrice", 400}, {"/maxPriorityFeePerGas", 200}} {
+			rp := sss.RetryPayload{
+				sss.PatchOperation{Op: "replace", Path: "/maxFeePerGas", Value: "4000000000"},
+				sss.PatchOperation{Op: "replace", Path: c.path, Value: "2"},
+			}
+			rb := new(bytes.Buffer)
+			json.NewEncoder(rb).Encode(rp)
+			rreq, err := http.NewRequest("PATCH", "/v1/proxy/transactions/"+rr.Body.String(), rb)
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+
+			rrr := httptest.NewRecorder()
+			retryHandler(client, londonSigner, newTestValidator(t, rules), nil, accounts, db).ServeHTTP(rrr, rreq)
+			if rrr.Code != c.code {
+				t.Errorf("%v: response code = %v, want %v", c.path, rrr.Code, c.code)
+			}
+		}
+		client.Commit()
+
+		want := big.NewInt(10000000000)
+		if got, _ := client.BalanceAt(context.Background(), tester.From, nil); !reflect.DeepEqual(got, want) {
+			t.Errorf("tester balance = %v, want %v", got, want)
+		}
+		if db.txs[0].MaxFeePerGas != "4000000000" || db.txs[0].MaxPriorityFeePerGas != "2" {
+			t.Errorf("recorded fees = %v %v", db.txs[0].MaxFeePerGas, db.txs[0].MaxPriorityFeePerGas)
+		}
+	})
+
+}
+
+func Test_retryAccess(t *testing.T) {
+	ownerKey, _ := crypto.GenerateKey()
+	owner := bind.NewKeyedTransactor(ownerKey)
+
+	testerKey, _ := crypto.GenerateKey()
+	tester := bind.NewKeyedTransactor(testerKey)
+
+	rules := `function validate(tx) return true end`
+	signer := types.HomesteadSigner{}
+
+	t.Run("Retries only the transactions of the caller with their rules", func(t *testing.T) {
+		client := backends.NewSimulatedBackend(core.GenesisAlloc{
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+		}, 4000000)
+		db := &dbMock{}
+		accounts, err := newKeyring(context.Background(), client, db, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		p := sss.TxPayload{To: tester.From.Hex(), Value: "10000000000", GasPrice: "1000000000"}
+		b := new(bytes.Buffer)
+		json.NewEncoder(b).Encode(p)
+		req, err := http.NewRequest("POST", "/v1/proxy/transactions", b)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		rr := httptest.NewRecorder()
+		txHandler(client, signer, nil, newTestValidator(t, rules), accounts, db).ServeHTTP(rr, withCaller(req, &apiKey{ID: "alice"}))
+		client.Rollback()
+		hash := rr.Body.String()
+
+		// The rules of alice changed since, and forbid the retry
+		keys := apiKeys{"alice": &apiKey{ID: "alice", Enabled: true, rules: newTestValidator(t, `function validate(tx) return false end`)}}
+		for _, c := range []struct {
+			key  *apiKey
+			want int
+		}{
+			{&apiKey{ID: "bob"}, 404},
+			{&apiKey{ID: "root", Scopes: []string{scopeRetry, scopeAdmin}}, 403},
+			{&apiKey{ID: "alice"}, 403},
+		} {
+			rp := sss.RetryPayload{
+				sss.PatchOperation{Op: "replace", Path: "/gasPrice", Value: "2000000000"},
+			}
+			rb := new(bytes.Buffer)
+			json.NewEncoder(rb).Encode(rp)
+			rreq, err := http.NewRequest("PATCH", "/v1/proxy/transactions/"+hash, rb)
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+
+			rrr := httptest.NewRecorder()
+			retryHandler(client, signer, newTestValidator(t, rules), keys, accounts, db).ServeHTTP(rrr, withCaller(rreq, c.key))
+
+			if rrr.Code != c.want {
+				t.Errorf("%v: response code = %v, want %v", c.key.ID, rrr.Code, c.want)
+			}
+		}
+	})
 }
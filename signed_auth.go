@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+)
+
+// maxSignedBody is the largest body of a signed request. It's read before
+// the signature can be checked.
+const maxSignedBody = 1 << 20
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// signedAuth are the parameters of a request signed with HMAC
+type signedAuth struct {
+	keyID     string
+	timestamp string
+	nonce     string
+	signature string
+}
+
+// parseSignedAuth reads an Authorization header of the sss.SignatureScheme
+func parseSignedAuth(header string) (signedAuth, bool) {
+	var s signedAuth
+	if !strings.HasPrefix(header, sss.SignatureScheme+" ") {
+		return s, false
+	}
+	for _, param := range strings.Split(strings.TrimPrefix(header, sss.SignatureScheme+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "keyId":
+			s.keyID = kv[1]
+		case "timestamp":
+			s.timestamp = kv[1]
+		case "nonce":
+			s.nonce = kv[1]
+		case "signature":
+			s.signature = kv[1]
+		}
+	}
+	return s, true
+}
+
+// verifySigned checks the signature, the freshness and the nonce of a signed
+// request. The body is read, up to maxSignedBody, and put back for the
+// handler.
+func (a *authenticator) verifySigned(r *http.Request, s signedAuth) (*apiKey, error) {
+	k, ok := a.keys[s.keyID]
+	if !ok || !k.Enabled || k.HMACSecret == "" || s.signature == "" || s.nonce == "" {
+		return nil, errors.New("unauthorized")
+	}
+
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+		if err != nil {
+			return nil, errors.New("unauthorized: error reading body")
+		}
+		if len(body) > maxSignedBody {
+			return nil, errBodyTooLarge
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	want := sss.RequestSignature(k.HMACSecret, r.Method, r.URL.RequestURI(), s.timestamp, s.nonce, body)
+	if !hmac.Equal([]byte(want), []byte(strings.ToLower(s.signature))) {
+		return nil, errors.New("unauthorized")
+	}
+
+	seconds, err := strconv.ParseInt(s.timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.New("unauthorized: invalid timestamp")
+	}
+	if !a.replays.fresh(time.Unix(seconds, 0)) {
+		return nil, errors.New("unauthorized: stale timestamp")
+	}
+	if !a.replays.first(k.ID, s.nonce, time.Unix(seconds, 0)) {
+		return nil, errors.New("unauthorized: replayed nonce")
+	}
+	return k, nil
+}
+
+// replayGuard refuses signed requests out of the allowed clock skew, and
+// remembers the nonces of the others until they become stale
+type replayGuard struct {
+	maxSkew time.Duration
+
+	mu     sync.Mutex
+	nonces map[string]time.Time // expiry by key and nonce
+	now    func() time.Time
+}
+
+func newReplayGuard(maxSkew time.Duration) *replayGuard {
+	return &replayGuard{
+		maxSkew: maxSkew,
+		nonces:  map[string]time.Time{},
+		now:     time.Now,
+	}
+}
+
+// fresh tells if a timestamp is within the allowed skew
+func (g *replayGuard) fresh(timestamp time.Time) bool {
+	skew := g.now().Sub(timestamp)
+	return skew <= g.maxSkew && -skew <= g.maxSkew
+}
+
+// first records a nonce, and tells if it wasn't seen before
+func (g *replayGuard) first(keyID, nonce string, timestamp time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	for n, expiry := range g.nonces {
+		if now.After(expiry) {
+			delete(g.nonces, n)
+		}
+	}
+
+	n := keyID + ":" + nonce
+	if _, ok := g.nonces[n]; ok {
+		return false
+	}
+	// The nonce can't be replayed once the timestamp is stale
+	g.nonces[n] = timestamp.Add(g.maxSkew)
+	return true
+}
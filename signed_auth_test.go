@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func Test_signedAuth(t *testing.T) {
+	keys, err := envAPIKeys("mobile", "", "s3cret")
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	var gotCaller, gotBody string
+	server := httptest.NewServer(newAuthenticator(keys, nil, time.Minute, nil, nil).require(scopeSend, func(w http.ResponseWriter, r *http.Request) {
+		gotCaller = callerID(r)
+		b, _ := ioutil.ReadAll(r.Body)
+		gotBody = string(b)
+	}))
+	defer server.Close()
+
+	send := func(method, body string, header string) int {
+		req, _ := http.NewRequest(method, server.URL+"/v1/proxy/transactions", bytes.NewReader([]byte(body)))
+		req.Header.Set("Authorization", header)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	sign := func(method, body, secret string, timestamp time.Time, nonce string) string {
+		ts := strconv.FormatInt(timestamp.Unix(), 10)
+		signature := sss.RequestSignature(secret, method, "/v1/proxy/transactions", ts, nonce, []byte(body))
+		return sss.SignatureScheme + " keyId=mobile,timestamp=" + ts + ",nonce=" + nonce + ",signature=" + signature
+	}
+
+	t.Run("Accepts the requests of a signing client", func(t *testing.T) {
+		to := common.HexToAddress("0x5597285BbE81BaF351e2C0884e9a5f4416958862")
+		resp, err := sss.NewSigningClient(server.URL, "mobile", "s3cret").Validate(&to, big.NewInt(1), nil, "")
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Errorf("response code = %v, want %v", resp.StatusCode, 200)
+		}
+		if gotCaller != "mobile" || gotBody == "" {
+			t.Errorf("caller = %v, body = %v", gotCaller, gotBody)
+		}
+	})
+
+	t.Run("Refuses a wrong secret or a tampered body", func(t *testing.T) {
+		if code := send("POST", `{"value":"1"}`, sign("POST", `{"value":"1"}`, "wrong", time.Now(), "n1")); code != 401 {
+			t.Errorf("response code = %v, want %v", code, 401)
+		}
+		if code := send("POST", `{"value":"2"}`, sign("POST", `{"value":"1"}`, "s3cret", time.Now(), "n2")); code != 401 {
+			t.Errorf("response code = %v, want %v", code, 401)
+		}
+		if code := send("GET", `{"value":"1"}`, sign("POST", `{"value":"1"}`, "s3cret", time.Now(), "n3")); code != 401 {
+			t.Errorf("response code = %v, want %v", code, 401)
+		}
+	})
+
+	t.Run("Refuses a body too large before checking it", func(t *testing.T) {
+		body := strings.Repeat(" ", maxSignedBody+1)
+		if code := send("POST", body, sign("POST", body, "s3cret", time.Now(), "n6")); code != 413 {
+			t.Errorf("response code = %v, want %v", code, 413)
+		}
+	})
+
+	t.Run("Refuses a stale timestamp", func(t *testing.T) {
+		for _, ts := range []time.Time{time.Now().Add(-2 * time.Minute), time.Now().Add(2 * time.Minute)} {
+			if code := send("POST", "{}", sign("POST", "{}", "s3cret", ts, "n4")); code != 401 {
+				t.Errorf("response code = %v, want %v", code, 401)
+			}
+		}
+	})
+
+	t.Run("Refuses a replayed nonce", func(t *testing.T) {
+		header := sign("POST", "{}", "s3cret", time.Now(), "n5")
+		if code := send("POST", "{}", header); code != 200 {
+			t.Errorf("response code = %v, want %v", code, 200)
+		}
+		if code := send("POST", "{}", header); code != 401 {
+			t.Errorf("response code = %v, want %v", code, 401)
+		}
+	})
+
+	t.Run("Refuses Basic auth for a key without secretHash", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", server.URL+"/v1/proxy/transactions", nil)
+		req.SetBasicAuth("mobile", "s3cret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 401 {
+			t.Errorf("response code = %v, want %v", resp.StatusCode, 401)
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Signer signs the transactions of an account. The key may live outside the
+// process.
+type Signer interface {
+	// SignTx returns the transaction signed for chainID, or for no chain in
+	// particular when chainID is nil
+	SignTx(ctx context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error)
+	// Address is the address of the account
+	Address() common.Address
+}
+
+// localSigner signs with a key held in memory, decrypted from a keystore file
+type localSigner struct {
+	key *ecdsa.PrivateKey
+}
+
+func newLocalSigner(key *ecdsa.PrivateKey) *localSigner {
+	return &localSigner{key: key}
+}
+
+func (s *localSigner) SignTx(ctx context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return types.SignTx(tx, types.LatestSignerForChainID(chainID), s.key)
+}
+
+func (s *localSigner) Address() common.Address {
+	return crypto.PubkeyToAddress(s.key.PublicKey)
+}
+
+// checkSigned makes sure a remote signer signed the given transaction, for
+// the given chain, with the key of address
+func checkSigned(tx, signed *types.Transaction, chainID *big.Int, address common.Address) error {
+	signer := types.LatestSignerForChainID(chainID)
+	if signed.Type() != tx.Type() || signer.Hash(signed) != signer.Hash(tx) {
+		return errors.New("the signer modified the transaction")
+	}
+	from, err := types.Sender(signer, signed)
+	if err != nil {
+		return err
+	}
+	if from != address {
+		return errors.New("signed by " + from.Hex() + " instead of " + address.Hex())
+	}
+	return nil
+}
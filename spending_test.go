@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+	"github.com/WeTrustPlatform/secure-signing-serv/whitelist"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func Test_spending(t *testing.T) {
+	t.Run("Can limit the spending", func(t *testing.T) {
+		a := "0x5597285BbE81BaF351e2C0884e9a5f4416958862"
+		b := "0xC7f965a58942dbf4E9fbdf77A511863d7041339d"
+		db := &dbMock{}
+		db.Create(&transaction{To: a, Value: "600000000000000000", Gas: 21000})
+		db.Create(&transaction{From: b, To: strings.ToLower(b), Value: "300000000000000000", Gas: 21000, APIKey: "alice"})
+		db.Create(&transaction{To: a, Value: "100000000000000000", Gas: 50000, GasUsed: 30000, Status: txStatusFailed})
+		db.Create(&transaction{To: "", Value: "0", Gas: 500000, GasUsed: 320000, Status: txStatusMined})
+		db.Create(&transaction{To: a, Value: "0", Gas: 21000, Status: txStatusDropped})
+		old := &transaction{To: a, Value: "500000000000000000", Gas: 21000}
+		old.CreatedAt = time.Now().Add(-2 * time.Hour)
+		db.Create(old)
+
+		rules := `
+function validate(tx)
+	local hour = spending{window = 3600}
+	local toB = spending{window = 3600, to = "0xC7f965a58942dbf4E9fbdf77A511863d7041339d"}
+	local fromB = spending{window = 3600, from = "0xc7f965a58942dbf4e9fbdf77a511863d7041339d"}
+	local deployments = spending{window = 86400, contractCreation = true}
+	local day = spending{window = 86400}
+	local alice = spending{window = 3600, apiKey = "alice"}
+	return hour.value == "900000000000000000" and hour.count == 3 and hour.gas == 392000
+		and toB.count == 1 and toB.value == "300000000000000000"
+		and fromB.count == 1 and fromB.value == "300000000000000000"
+		and deployments.count == 1
+		and day.count == 4
+		and alice.count == 1 and alice.value == "300000000000000000"
+		and hour.valueNumber + tx.valueNumber <= 1e18
+end
+`
+		v, err := whitelist.NewValidator(rules, nil, db, 0, whitelist.DefaultTimeout)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		for _, c := range []struct {
+			value int64
+			want  bool
+		}{{100000000000000000, true}, {200000000000000000, false}} {
+			tx := types.NewTransaction(1, common.HexToAddress(a), big.NewInt(c.value), 21000, big.NewInt(1), nil)
+			got, err := v.Validate(tx, common.Address{}, nil, whitelist.Caller{})
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			if got.Allowed != c.want {
+				t.Errorf("value %v: validate = %v, want %v", c.value, got.Allowed, c.want)
+			}
+		}
+	})
+
+	t.Run("Counts a recipient whatever its spelling", func(t *testing.T) {
+		ownerKey, _ := crypto.GenerateKey()
+		owner := bind.NewKeyedTransactor(ownerKey)
+		client := backends.NewSimulatedBackend(core.GenesisAlloc{
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+		}, 4000000)
+		db := &dbMock{}
+		accounts, err := newKeyring(context.Background(), client, db, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		v, err := whitelist.NewValidator(`function validate(tx) return spending{window = 3600, to = tx.to}.count < 1 end`, nil, db, 0, whitelist.DefaultTimeout)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		to := "0x5597285BbE81BaF351e2C0884e9a5f4416958862"
+		for _, c := range []struct {
+			to   string
+			want int
+		}{{strings.ToLower(to[2:]), 200}, {to, 403}} {
+			b := new(bytes.Buffer)
+			json.NewEncoder(b).Encode(sss.TxPayload{To: c.to, Value: "1", GasPrice: "1000000000"})
+			req, err := http.NewRequest("POST", "/v1/proxy/transactions", b)
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			rr := httptest.NewRecorder()
+			txHandler(client, types.HomesteadSigner{}, nil, v, accounts, db).ServeHTTP(rr, req)
+			if rr.Code != c.want {
+				t.Errorf("%v: response code = %v, want %v", c.to, rr.Code, c.want)
+			}
+		}
+		if len(db.txs) != 1 {
+			t.Fatalf("recorded %v transactions, want %v", len(db.txs), 1)
+		}
+		if db.txs[0].To != to {
+			t.Errorf("recorded to = %v, want %v", db.txs[0].To, to)
+		}
+	})
+}
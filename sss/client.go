@@ -13,6 +13,15 @@ import (
 // over HTTP
 type Client struct {
 	Endpoint string
+
+	// When set, requests are signed with HMAC-SHA256 instead of relying on
+	// credentials in the endpoint
+	KeyID  string
+	Secret string
+
+	// When set, transactions are sent from this account of the server
+	// instead of the default one
+	From string
 }
 
 // NewClient instantiates an 3S client to query the given endpoint
@@ -22,21 +31,51 @@ func NewClient(e string) *Client {
 	}
 }
 
-// Transact performs a transaction, a contract deployment or a contract call
+// NewSigningClient instantiates an 3S client signing its requests with the
+// secret of an API key
+func NewSigningClient(e, keyID, secret string) *Client {
+	return &Client{
+		Endpoint: e,
+		KeyID:    keyID,
+		Secret:   secret,
+	}
+}
+
+// Transact performs a transaction, a contract deployment or a contract call.
+// A nil gasPrice lets the server choose the fees.
 func (c *Client) Transact(to *common.Address, value, gasPrice *big.Int, data string) (*http.Response, error) {
-	toStr := ""
+	return c.transact(to, value, TxPayload{GasPrice: bigString(gasPrice)}, data)
+}
+
+// TransactDynamicFee performs an EIP-1559 transaction. The server fills in
+// the nil fee caps.
+func (c *Client) TransactDynamicFee(to *common.Address, value, maxFeePerGas, maxPriorityFeePerGas *big.Int, data string) (*http.Response, error) {
+	return c.transact(to, value, TxPayload{
+		MaxFeePerGas:         bigString(maxFeePerGas),
+		MaxPriorityFeePerGas: bigString(maxPriorityFeePerGas),
+	}, data)
+}
+
+// Validate checks a transaction against the server rules without sending it.
+// The response body is a JSON encoded Validation.
+func (c *Client) Validate(to *common.Address, value, gasPrice *big.Int, data string) (*http.Response, error) {
+	return c.post("/v1/proxy/transactions:validate", to, value, TxPayload{GasPrice: bigString(gasPrice)}, data)
+}
+
+func (c *Client) transact(to *common.Address, value *big.Int, p TxPayload, data string) (*http.Response, error) {
+	return c.post("/v1/proxy/transactions", to, value, p, data)
+}
+
+func (c *Client) post(path string, to *common.Address, value *big.Int, p TxPayload, data string) (*http.Response, error) {
 	if to != nil {
-		toStr = to.Hex()
-	}
-	p := TxPayload{
-		To:       toStr,
-		Value:    value.String(),
-		GasPrice: gasPrice.String(),
-		Data:     data,
+		p.To = to.Hex()
 	}
+	p.From = c.From
+	p.Value = value.String()
+	p.Data = data
 	b := new(bytes.Buffer)
 	json.NewEncoder(b).Encode(p)
-	return http.Post(c.Endpoint+"/v1/proxy/transactions", "application/json", b)
+	return c.do("POST", path, b.Bytes())
 }
 
 // Retry retries a failed transaction with a different gas price
@@ -50,5 +89,28 @@ func (c *Client) Retry(hash common.Hash, gasPrice *big.Int) (*http.Response, err
 	}
 	b := new(bytes.Buffer)
 	json.NewEncoder(b).Encode(p)
-	return http.Post(c.Endpoint+"/v1/proxy/transactions/"+hash.Hex(), "application/json", b)
+	return c.do("PATCH", "/v1/proxy/transactions/"+hash.Hex(), b.Bytes())
+}
+
+// do sends a JSON request, signed when the client has a secret
+func (c *Client) do(method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.Endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Secret != "" {
+		err = SignRequest(req, body, c.KeyID, c.Secret)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func bigString(i *big.Int) string {
+	if i == nil {
+		return ""
+	}
+	return i.String()
 }
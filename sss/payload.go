@@ -2,10 +2,13 @@ package sss
 
 // TxPayload to unmarshal requests JSON encoded body
 type TxPayload struct {
-	To       string `json:"to"`       // destination address, optional
-	Value    string `json:"value"`    // amount to be transferred, optional
-	GasPrice string `json:"gasPrice"` // price of the gas unit, mandatory
-	Data     string `json:"data"`     // hex encoded data, optional
+	From                 string `json:"from,omitempty"`                 // signing account, optional
+	To                   string `json:"to"`                             // destination address, optional
+	Value                string `json:"value"`                          // amount to be transferred, optional
+	GasPrice             string `json:"gasPrice,omitempty"`             // price of the gas unit, sends a legacy transaction, optional
+	MaxFeePerGas         string `json:"maxFeePerGas,omitempty"`         // EIP-1559 fee cap, optional
+	MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas,omitempty"` // EIP-1559 tip cap, optional
+	Data                 string `json:"data"`                           // hex encoded data, optional
 }
 
 // RetryPayload to unmarshal payload when patching a transaction
@@ -0,0 +1,42 @@
+package sss
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SignatureScheme is the Authorization scheme of the requests signed with a
+// shared secret:
+//
+//	Authorization: 3S-HMAC-SHA256 keyId=<id>,timestamp=<unix seconds>,nonce=<random>,signature=<hex>
+const SignatureScheme = "3S-HMAC-SHA256"
+
+// RequestSignature is the hex encoded HMAC-SHA256, keyed with the secret, of
+// the method, the path and query, the timestamp, the nonce and the hex encoded
+// SHA-256 of the body, separated by newlines
+func RequestSignature(secret, method, uri, timestamp, nonce string, body []byte) string {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(digest[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest sets the Authorization header of a request with the given body,
+// using the current time and a random nonce
+func SignRequest(r *http.Request, body []byte, keyID, secret string) error {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(b)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := RequestSignature(secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	r.Header.Set("Authorization", SignatureScheme+" keyId="+keyID+",timestamp="+timestamp+",nonce="+nonce+",signature="+signature)
+	return nil
+}
@@ -0,0 +1,54 @@
+package sss
+
+import "time"
+
+// Transaction is the stored state of a transaction sent by 3S
+type Transaction struct {
+	Type                 uint8     `json:"type"` // 0 for legacy, 2 for EIP-1559
+	From                 string    `json:"from"` // signing account
+	Nonce                uint64    `json:"nonce"`
+	To                   string    `json:"to"`                             // empty for contract creations
+	Value                string    `json:"value"`                          // in wei
+	Gas                  uint64    `json:"gas"`                            // gas limit
+	GasPrice             string    `json:"gasPrice,omitempty"`             // in wei, legacy transactions only
+	MaxFeePerGas         string    `json:"maxFeePerGas,omitempty"`         // in wei, EIP-1559 only
+	MaxPriorityFeePerGas string    `json:"maxPriorityFeePerGas,omitempty"` // in wei, EIP-1559 only
+	Data                 string    `json:"data"`                           // hex encoded
+	APIKey               string    `json:"apiKey,omitempty"`               // ID of the API key that sent it
+	ClientCert           string    `json:"clientCert,omitempty"`           // identity of the TLS client certificate that sent it
+	Hash                 string    `json:"hash"`                           // hash of the latest replacement
+	PreviousHashes       []string  `json:"previousHashes"`                 // replaced hashes, oldest first
+	Status               string    `json:"status"`                         // pending, mined, failed or dropped
+	BlockNumber          uint64    `json:"blockNumber,omitempty"`          // set once mined
+	BlockHash            string    `json:"blockHash,omitempty"`            // set once mined
+	GasUsed              uint64    `json:"gasUsed,omitempty"`              // set once mined
+	Fee                  string    `json:"fee,omitempty"`                  // gasUsed * effective gas price, set once mined
+	CreatedAt            time.Time `json:"createdAt"`
+}
+
+// TransactionList is a page of transactions, newest first
+type TransactionList struct {
+	Transactions []Transaction `json:"transactions"`
+	NextCursor   string        `json:"nextCursor,omitempty"` // pass as cursor to get the next page
+}
+
+// Validation is the verdict of a dry run of a transaction
+type Validation struct {
+	Valid                bool   `json:"valid"`                          // whether the rules accept the transaction
+	Error                string `json:"error,omitempty"`                // why the rules couldn't run
+	Code                 string `json:"code,omitempty"`                 // reason code given by the rules
+	Message              string `json:"message,omitempty"`              // reason message given by the rules
+	From                 string `json:"from"`                           // signing address
+	Nonce                uint64 `json:"nonce"`                          // nonce the transaction would get
+	Gas                  uint64 `json:"gas"`                            // estimated gas limit
+	GasPrice             string `json:"gasPrice,omitempty"`             // in wei, legacy transactions only
+	MaxFeePerGas         string `json:"maxFeePerGas,omitempty"`         // in wei, EIP-1559 only
+	MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas,omitempty"` // in wei, EIP-1559 only
+}
+
+// Denial is the body of a 403 response, when the rules refuse a transaction
+type Denial struct {
+	Error   string `json:"error"`             // always "forbidden transaction"
+	Code    string `json:"code,omitempty"`    // reason code given by the rules
+	Message string `json:"message,omitempty"` // reason message given by the rules
+}
@@ -4,6 +4,7 @@
 package helloworld
 
 import (
+	"errors"
 	"math/big"
 	"strings"
 
@@ -17,29 +18,41 @@ import (
 
 // Reference imports to suppress errors if they are not otherwise used.
 var (
+	_ = errors.New
 	_ = big.NewInt
 	_ = strings.NewReader
 	_ = ethereum.NotFound
-	_ = abi.U256
 	_ = bind.Bind
 	_ = common.Big1
 	_ = types.BloomLookup
 	_ = event.NewSubscription
 )
 
+// HelloWorldMetaData contains all meta data concerning the HelloWorld contract.
+var HelloWorldMetaData = &bind.MetaData{
+	ABI: "[{\"constant\":false,\"inputs\":[{\"name\":\"m\",\"type\":\"string\"}],\"name\":\"setMessage\",\"outputs\":[],\"payable\":false,\"stateMutability\":\"nonpayable\",\"type\":\"function\"},{\"constant\":true,\"inputs\":[],\"name\":\"message\",\"outputs\":[{\"name\":\"\",\"type\":\"string\"}],\"payable\":false,\"stateMutability\":\"view\",\"type\":\"function\"},{\"inputs\":[],\"payable\":false,\"stateMutability\":\"nonpayable\",\"type\":\"constructor\"},{\"anonymous\":false,\"inputs\":[{\"indexed\":true,\"name\":\"sender\",\"type\":\"address\"},{\"indexed\":false,\"name\":\"message\",\"type\":\"string\"}],\"name\":\"Updated\",\"type\":\"event\"}]",
+	Bin: "0x608060405234801561001057600080fd5b5060408051808201909152600c8082527f68656c6c6f20776f726c6421000000000000000000000000000000000000000060209092019182526100559160009161005b565b506100f6565b828054600181600116156101000203166002900490600052602060002090601f016020900481019282601f1061009c57805160ff19168380011785556100c9565b828001600101855582156100c9579182015b828111156100c95782518255916020019190600101906100ae565b506100d59291506100d9565b5090565b6100f391905b808211156100d557600081556001016100df565b90565b610365806101056000396000f3fe608060405234801561001057600080fd5b50600436106100365760003560e01c8063368b87721461003b578063e21f37ce146100e3575b600080fd5b6100e16004803603602081101561005157600080fd5b81019060208101813564010000000081111561006c57600080fd5b82018360208201111561007e57600080fd5b803590602001918460018302840111640100000000831117156100a057600080fd5b91908080601f016020809104026020016040519081016040528093929190818152602001838380828437600092019190915250929550610160945050505050565b005b6100eb610210565b6040805160208082528351818301528351919283929083019185019080838360005b8381101561012557818101518382015260200161010d565b50505050905090810190601f1680156101525780820380516001836020036101000a031916815260200191505b509250505060405180910390f35b805161017390600090602084019061029e565b50604080516020808252835181830152835133937f2ef3fc8a662077a0e040f48a65ffa7573c31f49d3f910d11faaebafb4024c6529386939092839283019185019080838360005b838110156101d35781810151838201526020016101bb565b50505050905090810190601f1680156102005780820380516001836020036101000a031916815260200191505b509250505060405180910390a250565b6000805460408051602060026001851615610100026000190190941693909304601f810184900484028201840190925281815292918301828280156102965780601f1061026b57610100808354040283529160200191610296565b820191906000526020600020905b81548152906001019060200180831161027957829003601f168201915b505050505081565b828054600181600116156101000203166002900490600052602060002090601f016020900481019282601f106102df57805160ff191683800117855561030c565b8280016001018555821561030c579182015b8281111561030c5782518255916020019190600101906102f1565b5061031892915061031c565b5090565b61033691905b808211156103185760008155600101610322565b9056fea165627a7a72305820a9ef249bf31d8f35838cabe1e58664dd84b47baf7830cda567c5e7ae48ee5b320029",
+}
+
 // HelloWorldABI is the input ABI used to generate the binding from.
-const HelloWorldABI = "[{\"constant\":false,\"inputs\":[{\"name\":\"m\",\"type\":\"string\"}],\"name\":\"setMessage\",\"outputs\":[],\"payable\":false,\"stateMutability\":\"nonpayable\",\"type\":\"function\"},{\"constant\":true,\"inputs\":[],\"name\":\"message\",\"outputs\":[{\"name\":\"\",\"type\":\"string\"}],\"payable\":false,\"stateMutability\":\"view\",\"type\":\"function\"},{\"inputs\":[],\"payable\":false,\"stateMutability\":\"nonpayable\",\"type\":\"constructor\"},{\"anonymous\":false,\"inputs\":[{\"indexed\":true,\"name\":\"sender\",\"type\":\"address\"},{\"indexed\":false,\"name\":\"message\",\"type\":\"string\"}],\"name\":\"Updated\",\"type\":\"event\"}]"
+// Deprecated: Use HelloWorldMetaData.ABI instead.
+var HelloWorldABI = HelloWorldMetaData.ABI
 
 // HelloWorldBin is the compiled bytecode used for deploying new contracts.
-const HelloWorldBin = `0x608060405234801561001057600080fd5b5060408051808201909152600c8082527f68656c6c6f20776f726c6421000000000000000000000000000000000000000060209092019182526100559160009161005b565b506100f6565b828054600181600116156101000203166002900490600052602060002090601f016020900481019282601f1061009c57805160ff19168380011785556100c9565b828001600101855582156100c9579182015b828111156100c95782518255916020019190600101906100ae565b506100d59291506100d9565b5090565b6100f391905b808211156100d557600081556001016100df565b90565b610365806101056000396000f3fe608060405234801561001057600080fd5b50600436106100365760003560e01c8063368b87721461003b578063e21f37ce146100e3575b600080fd5b6100e16004803603602081101561005157600080fd5b81019060208101813564010000000081111561006c57600080fd5b82018360208201111561007e57600080fd5b803590602001918460018302840111640100000000831117156100a057600080fd5b91908080601f016020809104026020016040519081016040528093929190818152602001838380828437600092019190915250929550610160945050505050565b005b6100eb610210565b6040805160208082528351818301528351919283929083019185019080838360005b8381101561012557818101518382015260200161010d565b50505050905090810190601f1680156101525780820380516001836020036101000a031916815260200191505b509250505060405180910390f35b805161017390600090602084019061029e565b50604080516020808252835181830152835133937f2ef3fc8a662077a0e040f48a65ffa7573c31f49d3f910d11faaebafb4024c6529386939092839283019185019080838360005b838110156101d35781810151838201526020016101bb565b50505050905090810190601f1680156102005780820380516001836020036101000a031916815260200191505b509250505060405180910390a250565b6000805460408051602060026001851615610100026000190190941693909304601f810184900484028201840190925281815292918301828280156102965780601f1061026b57610100808354040283529160200191610296565b820191906000526020600020905b81548152906001019060200180831161027957829003601f168201915b505050505081565b828054600181600116156101000203166002900490600052602060002090601f016020900481019282601f106102df57805160ff191683800117855561030c565b8280016001018555821561030c579182015b8281111561030c5782518255916020019190600101906102f1565b5061031892915061031c565b5090565b61033691905b808211156103185760008155600101610322565b9056fea165627a7a72305820a9ef249bf31d8f35838cabe1e58664dd84b47baf7830cda567c5e7ae48ee5b320029`
+// Deprecated: Use HelloWorldMetaData.Bin instead.
+var HelloWorldBin = HelloWorldMetaData.Bin
 
 // DeployHelloWorld deploys a new Ethereum contract, binding an instance of HelloWorld to it.
 func DeployHelloWorld(auth *bind.TransactOpts, backend bind.ContractBackend) (common.Address, *types.Transaction, *HelloWorld, error) {
-	parsed, err := abi.JSON(strings.NewReader(HelloWorldABI))
+	parsed, err := HelloWorldMetaData.GetAbi()
 	if err != nil {
 		return common.Address{}, nil, nil, err
 	}
-	address, tx, contract, err := bind.DeployContract(auth, parsed, common.FromHex(HelloWorldBin), backend)
+	if parsed == nil {
+		return common.Address{}, nil, nil, errors.New("GetABI returned nil")
+	}
+
+	address, tx, contract, err := bind.DeployContract(auth, *parsed, common.FromHex(HelloWorldBin), backend)
 	if err != nil {
 		return common.Address{}, nil, nil, err
 	}
@@ -154,7 +167,7 @@ func bindHelloWorld(address common.Address, caller bind.ContractCaller, transact
 // sets the output to result. The result type might be a single field for simple
 // returns, a slice of interfaces for anonymous returns and a struct for named
 // returns.
-func (_HelloWorld *HelloWorldRaw) Call(opts *bind.CallOpts, result interface{}, method string, params ...interface{}) error {
+func (_HelloWorld *HelloWorldRaw) Call(opts *bind.CallOpts, result *[]interface{}, method string, params ...interface{}) error {
 	return _HelloWorld.Contract.HelloWorldCaller.contract.Call(opts, result, method, params...)
 }
 
@@ -173,7 +186,7 @@ func (_HelloWorld *HelloWorldRaw) Transact(opts *bind.TransactOpts, method strin
 // sets the output to result. The result type might be a single field for simple
 // returns, a slice of interfaces for anonymous returns and a struct for named
 // returns.
-func (_HelloWorld *HelloWorldCallerRaw) Call(opts *bind.CallOpts, result interface{}, method string, params ...interface{}) error {
+func (_HelloWorld *HelloWorldCallerRaw) Call(opts *bind.CallOpts, result *[]interface{}, method string, params ...interface{}) error {
 	return _HelloWorld.Contract.contract.Call(opts, result, method, params...)
 }
 
@@ -190,26 +203,31 @@ func (_HelloWorld *HelloWorldTransactorRaw) Transact(opts *bind.TransactOpts, me
 
 // Message is a free data retrieval call binding the contract method 0xe21f37ce.
 //
-// Solidity: function message() constant returns(string)
+// Solidity: function message() view returns(string)
 func (_HelloWorld *HelloWorldCaller) Message(opts *bind.CallOpts) (string, error) {
-	var (
-		ret0 = new(string)
-	)
-	out := ret0
-	err := _HelloWorld.contract.Call(opts, out, "message")
-	return *ret0, err
+	var out []interface{}
+	err := _HelloWorld.contract.Call(opts, &out, "message")
+
+	if err != nil {
+		return *new(string), err
+	}
+
+	out0 := *abi.ConvertType(out[0], new(string)).(*string)
+
+	return out0, err
+
 }
 
 // Message is a free data retrieval call binding the contract method 0xe21f37ce.
 //
-// Solidity: function message() constant returns(string)
+// Solidity: function message() view returns(string)
 func (_HelloWorld *HelloWorldSession) Message() (string, error) {
 	return _HelloWorld.Contract.Message(&_HelloWorld.CallOpts)
 }
 
 // Message is a free data retrieval call binding the contract method 0xe21f37ce.
 //
-// Solidity: function message() constant returns(string)
+// Solidity: function message() view returns(string)
 func (_HelloWorld *HelloWorldCallerSession) Message() (string, error) {
 	return _HelloWorld.Contract.Message(&_HelloWorld.CallOpts)
 }
@@ -368,3 +386,14 @@ func (_HelloWorld *HelloWorldFilterer) WatchUpdated(opts *bind.WatchOpts, sink c
 	}), nil
 }
 
+// ParseUpdated is a log parse operation binding the contract event 0x2ef3fc8a662077a0e040f48a65ffa7573c31f49d3f910d11faaebafb4024c652.
+//
+// Solidity: event Updated(address indexed sender, string message)
+func (_HelloWorld *HelloWorldFilterer) ParseUpdated(log types.Log) (*HelloWorldUpdated, error) {
+	event := new(HelloWorldUpdated)
+	if err := _HelloWorld.contract.UnpackLog(event, "Updated", log); err != nil {
+		return nil, err
+	}
+	event.Raw = log
+	return event, nil
+}
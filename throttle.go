@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// authThrottle counts the failed authentications per source IP and per user,
+// and locks them out for a while after too many failures in a window. A nil
+// throttle doesn't limit anything.
+type authThrottle struct {
+	maxFailures int
+	window      time.Duration // failures older than that are forgotten
+	lockout     time.Duration
+	trustProxy  bool // read the source IP from X-Forwarded-For
+
+	mu       sync.Mutex
+	failures map[string]*authFailures
+	now      func() time.Time
+}
+
+type authFailures struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+func newAuthThrottle(maxFailures int, window, lockout time.Duration, trustProxy bool) *authThrottle {
+	return &authThrottle{
+		maxFailures: maxFailures,
+		window:      window,
+		lockout:     lockout,
+		trustProxy:  trustProxy,
+		failures:    map[string]*authFailures{},
+		now:         time.Now,
+	}
+}
+
+// clientIP returns the source IP of a request. Behind a proxy, it is the last
+// address of X-Forwarded-For, the one added by the proxy.
+func (t *authThrottle) clientIP(r *http.Request) string {
+	if t != nil && t.trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			addresses := strings.Split(forwarded, ",")
+			return strings.TrimSpace(addresses[len(addresses)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// locked returns how long the IP or the user is still locked out
+func (t *authThrottle) locked(ip, user string) time.Duration {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	var wait time.Duration
+	for _, key := range throttleKeys(ip, user) {
+		if f, ok := t.failures[key]; ok && f.lockedUntil.After(now) && f.lockedUntil.Sub(now) > wait {
+			wait = f.lockedUntil.Sub(now)
+		}
+	}
+	return wait
+}
+
+// fail counts a failed authentication, and locks out the IP or the user when
+// they reach the maximum
+func (t *authThrottle) fail(ip, user string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.forgetExpired(now)
+	for _, key := range throttleKeys(ip, user) {
+		f, ok := t.failures[key]
+		if !ok || now.Sub(f.first) > t.window {
+			f = &authFailures{first: now}
+			t.failures[key] = f
+		}
+		f.count++
+		if f.count >= t.maxFailures {
+			f.lockedUntil = now.Add(t.lockout)
+			log.WithFields(log.Fields{
+				"IP":       ip,
+				"User":     user,
+				"Locked":   key,
+				"Failures": f.count,
+				"Until":    f.lockedUntil,
+			}).Warning("Too many failed authentications, possible brute-force attempt")
+		}
+	}
+}
+
+// succeed forgets the failures of the IP and the user
+func (t *authThrottle) succeed(ip, user string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.failures, "ip:"+ip)
+	delete(t.failures, "user:"+user)
+}
+
+// throttleKeys are the counters of an attempt. Attempts without user, such as
+// bearer tokens, are only counted per IP.
+func throttleKeys(ip, user string) []string {
+	if user == "" {
+		return []string{"ip:" + ip}
+	}
+	return []string{"ip:" + ip, "user:" + user}
+}
+
+// forgetExpired drops the failures out of their window and lockout, so
+// scanning many IPs or users doesn't grow the memory forever
+func (t *authThrottle) forgetExpired(now time.Time) {
+	for key, f := range t.failures {
+		if now.Sub(f.first) > t.window && !f.lockedUntil.After(now) {
+			delete(t.failures, key)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_authThrottle(t *testing.T) {
+	keys, err := envAPIKeys("user", hashSecret("secret"), "")
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	newThrottle := func() (*authThrottle, *time.Time) {
+		now := time.Date(2019, 8, 1, 0, 0, 0, 0, time.UTC)
+		throttle := newAuthThrottle(3, time.Minute, 10*time.Minute, false)
+		throttle.now = func() time.Time { return now }
+		return throttle, &now
+	}
+	call := func(throttle *authThrottle, ip, user, pass string) int {
+		h := newAuthenticator(keys, throttle, time.Minute, nil, nil).require(scopeSend, func(w http.ResponseWriter, r *http.Request) {})
+		req, _ := http.NewRequest("GET", "/v1/proxy/transactions", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.SetBasicAuth(user, pass)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	t.Run("Locks out a user after too many failures", func(t *testing.T) {
+		throttle, now := newThrottle()
+		for i, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+			if code := call(throttle, ip, "user", "wrong"); code != 401 {
+				t.Errorf("attempt %v: response code = %v, want %v", i, code, 401)
+			}
+		}
+		if code := call(throttle, "10.0.0.4", "user", "secret"); code != 429 {
+			t.Errorf("response code = %v, want %v", code, 429)
+		}
+
+		*now = now.Add(11 * time.Minute)
+		if code := call(throttle, "10.0.0.4", "user", "secret"); code != 200 {
+			t.Errorf("response code = %v, want %v", code, 200)
+		}
+	})
+
+	t.Run("Locks out an IP trying several users", func(t *testing.T) {
+		throttle, _ := newThrottle()
+		for _, user := range []string{"a", "b", "c"} {
+			call(throttle, "10.0.0.1", user, "wrong")
+		}
+		if code := call(throttle, "10.0.0.1", "user", "secret"); code != 429 {
+			t.Errorf("response code = %v, want %v", code, 429)
+		}
+		if code := call(throttle, "10.0.0.2", "user", "secret"); code != 200 {
+			t.Errorf("response code = %v, want %v", code, 200)
+		}
+	})
+
+	t.Run("Forgets failures out of the window or after a success", func(t *testing.T) {
+		throttle, now := newThrottle()
+		call(throttle, "10.0.0.1", "user", "wrong")
+		call(throttle, "10.0.0.1", "user", "wrong")
+		*now = now.Add(2 * time.Minute)
+		call(throttle, "10.0.0.1", "user", "wrong")
+		call(throttle, "10.0.0.1", "user", "secret")
+		call(throttle, "10.0.0.1", "user", "wrong")
+		call(throttle, "10.0.0.1", "user", "wrong")
+		if code := call(throttle, "10.0.0.1", "user", "secret"); code != 200 {
+			t.Errorf("response code = %v, want %v", code, 200)
+		}
+	})
+
+	t.Run("Reads the IP added by the proxy", func(t *testing.T) {
+		throttle := newAuthThrottle(3, time.Minute, time.Minute, true)
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", "1.2.3.4, 5.6.7.8")
+		if got := throttle.clientIP(req); got != "5.6.7.8" {
+			t.Errorf("client IP = %v, want %v", got, "5.6.7.8")
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// certReloader serves the TLS certificate of the service and, for mutual TLS,
+// the CAs of the client certificates. Reload reads the files again, so
+// certificates can be renewed without a restart.
+type certReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string // empty to not ask for client certificates
+	clientAuth   tls.ClientAuthType
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func newCertReloader(certFile, keyFile, clientCAFile string, requireClientCert bool) (*certReloader, error) {
+	c := &certReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+		clientAuth:   tls.NoClientCert,
+	}
+	if clientCAFile != "" {
+		c.clientAuth = tls.VerifyClientCertIfGiven
+		if requireClientCert {
+			c.clientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	err := c.Reload()
+	return c, err
+}
+
+// Reload reads the certificates again. The previous ones stay in use if the
+// new ones can't be loaded.
+func (c *certReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Cert":  c.certFile,
+			"error": err.Error(),
+		}).Error("Error loading the TLS certificate, keeping the previous one.")
+		return err
+	}
+
+	var clientCAs *x509.CertPool
+	if c.clientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.clientCAFile)
+		if err == nil {
+			clientCAs = x509.NewCertPool()
+			if !clientCAs.AppendCertsFromPEM(pem) {
+				err = errors.New("no certificate in the client CA file")
+			}
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"ClientCA": c.clientCAFile,
+				"error":    err.Error(),
+			}).Error("Error loading the client CAs, keeping the previous ones.")
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.clientCAs = clientCAs
+	c.mu.Unlock()
+
+	log.WithFields(log.Fields{
+		"Cert":     c.certFile,
+		"ClientCA": c.clientCAFile,
+	}).Info("Loaded the TLS certificates")
+	return nil
+}
+
+// config is the TLS configuration of the listener. Every handshake gets the
+// certificates loaded last, and HTTP/2 when the client supports it.
+func (c *certReloader) config() *tls.Config {
+	nextProtos := []string{"h2", "http/1.1"}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     nextProtos,
+		GetCertificate: c.certificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+			return &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				NextProtos:     nextProtos,
+				GetCertificate: c.certificate,
+				ClientAuth:     c.clientAuth,
+				ClientCAs:      c.clientCAs,
+			}, nil
+		},
+	}
+}
+
+func (c *certReloader) certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// clientCertID is the identity of the verified client certificate of a
+// request: its first URI, DNS or email SAN, or else its subject common name.
+// It is empty without a verified certificate.
+func clientCertID(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	switch {
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	}
+	return cert.Subject.CommonName
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate signed by a test CA, or self-signed when the CA
+// is nil
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, ca *testCert, template *x509.Certificate) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	parent, parentKey := template, key
+	if ca != nil {
+		parent, parentKey = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write saves the certificate and its key as PEM files
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyFile == "" {
+		return
+	}
+	b, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *testCert) tls() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// tlsFixture is a CA with the certificates of a server and its clients, and
+// a service authenticating them
+type tlsFixture struct {
+	dir      string
+	certFile string
+	keyFile  string
+	caFile   string
+
+	ca       *testCert
+	mobile   *testCert
+	backend  *testCert
+	stranger *testCert
+	roots    *x509.CertPool
+	auth     *authenticator
+}
+
+// newTLSFixture writes the files of the fixture to a new directory, to
+// remove after the test
+func newTLSFixture(t *testing.T) *tlsFixture {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &tlsFixture{
+		dir:      dir,
+		certFile: filepath.Join(dir, "server.pem"),
+		keyFile:  filepath.Join(dir, "server-key.pem"),
+		caFile:   filepath.Join(dir, "ca.pem"),
+	}
+
+	f.ca = newTestCert(t, nil, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	f.ca.write(t, f.caFile, "")
+	f.newServerCert(t, "server 1").write(t, f.certFile, f.keyFile)
+
+	mobileURI, _ := url.Parse("spiffe://example.com/mobile")
+	f.mobile = newTestCert(t, f.ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "mobile"},
+		URIs:        []*url.URL{mobileURI},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	f.backend = newTestCert(t, f.ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "backend"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	f.stranger = newTestCert(t, nil, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "stranger"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	f.roots = x509.NewCertPool()
+	f.roots.AddCert(f.ca.cert)
+
+	keys, err := envAPIKeys("user", hashSecret("secret"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.auth = newAuthenticator(keys, nil, time.Minute, nil, []string{scopeSend})
+	return f
+}
+
+func (f *tlsFixture) newServerCert(t *testing.T, name string) *testCert {
+	return newTestCert(t, f.ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+}
+
+// serve starts a TLS server recording the caller of the last request
+func (f *tlsFixture) serve(t *testing.T, requireClientCert bool) (*httptest.Server, *certReloader, *testCaller) {
+	certs, err := newCertReloader(f.certFile, f.keyFile, f.caFile, requireClientCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &testCaller{}
+	server := httptest.NewUnstartedServer(f.auth.require(scopeSend, func(w http.ResponseWriter, r *http.Request) {
+		got.id, got.clientCert = callerID(r), clientCertID(r)
+	}))
+	server.TLS = certs.config()
+	server.StartTLS()
+	return server, certs, got
+}
+
+// get calls the server with the certificate of client and the password of
+// user, either optional
+func (f *tlsFixture) get(server *httptest.Server, client *testCert, user string) (*http.Response, error) {
+	config := &tls.Config{RootCAs: f.roots}
+	if client != nil {
+		config.Certificates = []tls.Certificate{client.tls()}
+	}
+	c := http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	req, _ := http.NewRequest("GET", server.URL+"/v1/proxy/transactions", nil)
+	if user != "" {
+		req.SetBasicAuth(user, "secret")
+	}
+	resp, err := c.Do(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func Test_tls(t *testing.T) {
+	f := newTLSFixture(t)
+	defer os.RemoveAll(f.dir)
+	serve, get := f.serve, f.get
+	mobile, backend, stranger := f.mobile, f.backend, f.stranger
+
+	t.Run("Identifies the caller by its certificate", func(t *testing.T) {
+		server, _, got := serve(t, true)
+		defer server.Close()
+
+		for _, c := range []struct {
+			client *testCert
+			want   string
+		}{{mobile, "spiffe://example.com/mobile"}, {backend, "backend"}} {
+			resp, err := get(server, c.client, "")
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			if resp.StatusCode != 200 || got.id != c.want || got.clientCert != c.want {
+				t.Errorf("response code = %v, caller = %+v, want %v", resp.StatusCode, got, c.want)
+			}
+		}
+	})
+
+	t.Run("Records the certificate along the credentials", func(t *testing.T) {
+		server, _, got := serve(t, true)
+		defer server.Close()
+
+		resp, err := get(server, mobile, "user")
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if resp.StatusCode != 200 || got.id != "user" || got.clientCert != "spiffe://example.com/mobile" {
+			t.Errorf("response code = %v, caller = %+v", resp.StatusCode, got)
+		}
+	})
+
+	t.Run("Requires a certificate of the CA", func(t *testing.T) {
+		server, _, _ := serve(t, true)
+		defer server.Close()
+
+		for _, client := range []*testCert{nil, stranger} {
+			if _, err := get(server, client, "user"); err == nil {
+				t.Errorf("error = %v, want a handshake error", err)
+			}
+		}
+	})
+
+	t.Run("Makes the certificate optional", func(t *testing.T) {
+		server, _, got := serve(t, false)
+		defer server.Close()
+
+		resp, err := get(server, nil, "user")
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if resp.StatusCode != 200 || got.id != "user" || got.clientCert != "" {
+			t.Errorf("response code = %v, caller = %+v", resp.StatusCode, got)
+		}
+		if resp, _ := get(server, nil, ""); resp == nil || resp.StatusCode != 401 {
+			t.Errorf("response = %v, want a 401", resp)
+		}
+	})
+
+}
+
+func Test_tlsServer(t *testing.T) {
+	f := newTLSFixture(t)
+	defer os.RemoveAll(f.dir)
+
+	t.Run("Serves HTTP/2", func(t *testing.T) {
+		certs, err := newCertReloader(f.certFile, f.keyFile, f.caFile, false)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.EnableHTTP2 = true
+		server.TLS = certs.config()
+		server.StartTLS()
+		defer server.Close()
+
+		c := http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: f.roots},
+			ForceAttemptHTTP2: true,
+		}}
+		resp, err := c.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		resp.Body.Close()
+		if resp.ProtoMajor != 2 {
+			t.Errorf("protocol = %v, want %v", resp.Proto, "HTTP/2.0")
+		}
+	})
+
+	t.Run("Reloads the certificates", func(t *testing.T) {
+		server, certs, _ := f.serve(t, true)
+		defer server.Close()
+
+		f.newServerCert(t, "server 2").write(t, f.certFile, f.keyFile)
+		err := certs.Reload()
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		resp, err := f.get(server, f.mobile, "")
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if got := resp.TLS.PeerCertificates[0].Subject.CommonName; got != "server 2" {
+			t.Errorf("server certificate = %v, want %v", got, "server 2")
+		}
+
+		ioutil.WriteFile(f.keyFile, []byte("garbage"), 0600)
+		if err := certs.Reload(); err == nil {
+			t.Errorf("reload error = %v, want an error", err)
+		}
+		if _, err := f.get(server, f.mobile, ""); err != nil {
+			t.Errorf("error = %v, want the previous certificate", err)
+		}
+	})
+}
+
+func Test_certCaller(t *testing.T) {
+	t.Run("Refuses the unknown certificates without scopes", func(t *testing.T) {
+		keys := apiKeys{"backend": &apiKey{ID: "backend", Enabled: true}, "old": &apiKey{ID: "old"}}
+		a := newAuthenticator(keys, nil, time.Minute, nil, nil)
+		for _, id := range []string{"stranger", "old"} {
+			if k, err := a.certCaller(id); err == nil {
+				t.Errorf("%v: caller = %+v, want an error", id, k)
+			}
+		}
+		if k, err := a.certCaller("backend"); err != nil || k != keys["backend"] {
+			t.Errorf("caller = %+v, error = %v, want the key", k, err)
+		}
+
+		a = newAuthenticator(keys, nil, time.Minute, nil, []string{scopeRead})
+		k, err := a.certCaller("stranger")
+		if err != nil || k.hasScope(scopeSend) || !k.hasScope(scopeRead) || k.isAdmin() {
+			t.Errorf("caller = %+v, error = %v, want only %v", k, err, scopeRead)
+		}
+	})
+}
+
+// testCaller is the identity seen by a test handler
+type testCaller struct {
+	id         string
+	clientCert string
+}
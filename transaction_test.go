@@ -9,8 +9,10 @@ import (
 	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+	"github.com/WeTrustPlatform/secure-signing-serv/whitelist"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
 	"github.com/ethereum/go-ethereum/common"
@@ -19,6 +21,14 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
+func newTestValidator(t *testing.T, rules string) *whitelist.Validator {
+	v, err := whitelist.NewValidator(rules, nil, nil, 1, whitelist.DefaultTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
 func Test_transaction(t *testing.T) {
 	ctx := context.Background()
 
@@ -33,10 +43,16 @@ func Test_transaction(t *testing.T) {
 
 	t.Run("Can transact with value", func(t *testing.T) {
 		client := backends.NewSimulatedBackend(core.GenesisAlloc{
-			owner.From: core.GenesisAccount{Balance: big.NewInt(50000000000)},
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
 		}, 4000000)
+		db := &dbMock{}
+		accounts, err := newKeyring(ctx, client, db, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
 
-		p := sss.TxPayload{To: tester.From.Hex(), Value: "10000000000", GasPrice: "1"}
+		p := sss.TxPayload{To: tester.From.Hex(), Value: "10000000000", GasPrice: "1000000000"}
 		b := new(bytes.Buffer)
 		json.NewEncoder(b).Encode(p)
 		req, err := http.NewRequest("POST", "/tx", b)
@@ -45,7 +61,7 @@ func Test_transaction(t *testing.T) {
 			return
 		}
 
-		h := txHandler(client, signer, rules, owner.From, ownerKey, &dbMock{})
+		h := txHandler(client, signer, nil, newTestValidator(t, rules), accounts, db)
 		rr := httptest.NewRecorder()
 		h.ServeHTTP(rr, req)
 		client.Commit()
@@ -63,10 +79,16 @@ func Test_transaction(t *testing.T) {
 
 	t.Run("Can transact with data", func(t *testing.T) {
 		client := backends.NewSimulatedBackend(core.GenesisAlloc{
-			owner.From: core.GenesisAccount{Balance: big.NewInt(50000000000)},
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
 		}, 4000000)
+		db := &dbMock{}
+		accounts, err := newKeyring(ctx, client, db, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
 
-		p := sss.TxPayload{To: tester.From.Hex(), Value: "10000000000", GasPrice: "1", Data: "abcdef"}
+		p := sss.TxPayload{To: tester.From.Hex(), Value: "10000000000", GasPrice: "1000000000", Data: "abcdef"}
 		b := new(bytes.Buffer)
 		json.NewEncoder(b).Encode(p)
 		req, err := http.NewRequest("POST", "/tx", b)
@@ -75,7 +97,7 @@ func Test_transaction(t *testing.T) {
 			return
 		}
 
-		h := txHandler(client, signer, rules, owner.From, ownerKey, &dbMock{})
+		h := txHandler(client, signer, nil, newTestValidator(t, rules), accounts, db)
 		rr := httptest.NewRecorder()
 		h.ServeHTTP(rr, req)
 		client.Commit()
@@ -97,4 +119,194 @@ func Test_transaction(t *testing.T) {
 			return
 		}
 	})
+
+}
+
+func Test_transactionFees(t *testing.T) {
+	ctx := context.Background()
+
+	ownerKey, _ := crypto.GenerateKey()
+	owner := bind.NewKeyedTransactor(ownerKey)
+
+	testerKey, _ := crypto.GenerateKey()
+	tester := bind.NewKeyedTransactor(testerKey)
+
+	rules := `function validate(tx) return true end`
+	signer := types.HomesteadSigner{}
+
+	t.Run("Can transact with dynamic fees", func(t *testing.T) {
+		client := backends.NewSimulatedBackend(core.GenesisAlloc{
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+		}, 4000000)
+		db := &dbMock{}
+		accounts, err := newKeyring(ctx, client, db, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		p := sss.TxPayload{To: tester.From.Hex(), Value: "10000000000", MaxPriorityFeePerGas: "2"}
+		b := new(bytes.Buffer)
+		json.NewEncoder(b).Encode(p)
+		req, err := http.NewRequest("POST", "/tx", b)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		londonSigner := types.NewLondonSigner(big.NewInt(1337))
+		h := txHandler(client, londonSigner, nil, newTestValidator(t, rules), accounts, db)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		client.Commit()
+
+		if rr.Code != 200 {
+			t.Errorf("response code = %v, want %v", rr.Code, 200)
+			return
+		}
+
+		want := big.NewInt(10000000000)
+		if got, _ := client.BalanceAt(context.Background(), tester.From, nil); !reflect.DeepEqual(got, want) {
+			t.Errorf("tester balance = %v, want %v", got, want)
+			return
+		}
+
+		tx, _, err := client.TransactionByHash(ctx, common.HexToHash(rr.Body.String()))
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		if tx.Type() != types.DynamicFeeTxType {
+			t.Errorf("tx type = %v, want %v", tx.Type(), types.DynamicFeeTxType)
+		}
+		if tx.GasTipCap().Cmp(big.NewInt(2)) != 0 {
+			t.Errorf("tx tip cap = %v, want %v", tx.GasTipCap(), 2)
+		}
+		// The genesis base fee of the simulated chain is 1 gwei
+		if want := big.NewInt(2000000002); tx.GasFeeCap().Cmp(want) != 0 {
+			t.Errorf("tx fee cap = %v, want %v", tx.GasFeeCap(), want)
+		}
+		if db.txs[0].MaxFeePerGas != "2000000002" || db.txs[0].GasPrice != "" {
+			t.Errorf("recorded fees = %v %v", db.txs[0].MaxFeePerGas, db.txs[0].GasPrice)
+		}
+	})
+
+	t.Run("Rejects gasPrice combined with fee caps", func(t *testing.T) {
+		client := backends.NewSimulatedBackend(core.GenesisAlloc{
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+		}, 4000000)
+		db := &dbMock{}
+		accounts, err := newKeyring(ctx, client, db, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		p := sss.TxPayload{To: tester.From.Hex(), GasPrice: "1000000000", MaxFeePerGas: "1000000000"}
+		b := new(bytes.Buffer)
+		json.NewEncoder(b).Encode(p)
+		req, err := http.NewRequest("POST", "/tx", b)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		h := txHandler(client, signer, nil, newTestValidator(t, rules), accounts, db)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != 400 {
+			t.Errorf("response code = %v, want %v", rr.Code, 400)
+		}
+	})
+}
+
+func Test_transactionRules(t *testing.T) {
+	ctx := context.Background()
+
+	ownerKey, _ := crypto.GenerateKey()
+	owner := bind.NewKeyedTransactor(ownerKey)
+
+	testerKey, _ := crypto.GenerateKey()
+	tester := bind.NewKeyedTransactor(testerKey)
+
+	signer := types.HomesteadSigner{}
+
+	t.Run("Fails closed when the rules time out", func(t *testing.T) {
+		client := backends.NewSimulatedBackend(core.GenesisAlloc{
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+		}, 4000000)
+		db := &dbMock{}
+		accounts, err := newKeyring(ctx, client, db, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		slow, err := whitelist.NewValidator(`function validate(tx) while true do end end`, nil, nil, 1, 50*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		p := sss.TxPayload{To: tester.From.Hex(), Value: "10000000000", GasPrice: "1000000000"}
+		b := new(bytes.Buffer)
+		json.NewEncoder(b).Encode(p)
+		req, err := http.NewRequest("POST", "/tx", b)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		h := txHandler(client, signer, nil, slow, accounts, db)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != 500 {
+			t.Errorf("response code = %v, want %v", rr.Code, 500)
+		}
+		if len(db.txs) != 0 {
+			t.Errorf("recorded transactions = %v, want %v", len(db.txs), 0)
+		}
+	})
+	t.Run("Returns the reason of a refusal", func(t *testing.T) {
+		client := backends.NewSimulatedBackend(core.GenesisAlloc{
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+		}, 4000000)
+		db := &dbMock{}
+		accounts, err := newKeyring(ctx, client, db, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		p := sss.TxPayload{To: tester.From.Hex(), Value: "10000000000", GasPrice: "1000000000"}
+		b := new(bytes.Buffer)
+		json.NewEncoder(b).Encode(p)
+		req, err := http.NewRequest("POST", "/tx", b)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		deny := newTestValidator(t, `function validate(tx) return false, {code = "limit", message = "value too high"} end`)
+		h := txHandler(client, signer, nil, deny, accounts, db)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != 403 {
+			t.Errorf("response code = %v, want %v", rr.Code, 403)
+			return
+		}
+		var got sss.Denial
+		err = json.NewDecoder(rr.Body).Decode(&got)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		want := sss.Denial{Error: "forbidden transaction", Code: "limit", Message: "value too high"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("denial = %+v, want %+v", got, want)
+		}
+	})
 }
@@ -2,12 +2,14 @@ package main
 
 import (
 	"context"
-	"crypto/ecdsa"
 	"encoding/json"
+	"errors"
 	"math/big"
 	"net/http"
+	"time"
 
 	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+	"github.com/WeTrustPlatform/secure-signing-serv/whitelist"
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -21,7 +23,13 @@ type Client interface {
 	ethereum.ChainStateReader
 	ethereum.TransactionSender
 	ethereum.GasEstimator
+	TransactionByHash(ctx context.Context, txHash common.Hash) (*types.Transaction, bool, error)
 	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
 }
 
 // Recorder allows mocking the database operations
@@ -29,18 +37,27 @@ type Recorder interface {
 	Create(interface{}) *gorm.DB
 	First(interface{}, ...interface{}) *gorm.DB
 	Save(interface{}) *gorm.DB
+	PendingTransactions() ([]transaction, error)
+	TransactionByHash(hash string) (transaction, bool, error)
+	PreviousHashes(id uint) ([]string, error)
+	PreviousHashesOf(ids []uint) (map[uint][]string, error)
+	ReplaceHash(id uint, old, new string) error
+	SetBroadcastBlock(id uint, hash string, block uint64) error
+	RecordOutcome(t transaction, hash string) error
+	RecordReplacement(t transaction, replaced string) error
+	ListTransactions(f txFilter) ([]transaction, error)
+	Spending(f whitelist.SpendingFilter) (whitelist.Spending, error)
 }
 
 func txHandler(
 	client Client,
 	signer types.Signer,
-	rules string,
-	owner common.Address,
-	key *ecdsa.PrivateKey,
+	oracle feeOracle,
+	rules whitelist.Rules,
+	accounts keyring,
 	db Recorder,
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var ok bool
 		ctx := context.Background()
 
 		decoder := json.NewDecoder(r.Body)
@@ -51,107 +68,80 @@ func txHandler(
 			return
 		}
 
-		var to *common.Address
-		if p.To != "" {
-			address := common.HexToAddress(p.To)
-			to = &address
-		}
-
-		value := new(big.Int)
-		if p.Value != "" {
-			value, ok = value.SetString(p.Value, 10)
-			if !ok {
-				http.Error(w, "couldn't convert value to big.Int", http.StatusBadRequest)
-				return
-			}
-		}
-
-		gp, ok := big.NewInt(0).SetString(p.GasPrice, 10)
-		if !ok {
-			http.Error(w, "couldn't convert gasPrice to big.Int", http.StatusBadRequest)
-			return
-		}
-
-		data := common.Hex2Bytes(p.Data)
-
-		gas, err := client.EstimateGas(ctx, ethereum.CallMsg{
-			From:  owner,
-			To:    to,
-			Value: value,
-			Data:  data,
-		})
+		sender, code, err := accounts.pick(ctx, client, r, p)
 		if err != nil {
-			log.WithFields(log.Fields{
-				"To":       p.To,
-				"Value":    value.String(),
-				"Gas":      gas,
-				"GasPrice": gp.String(),
-				"error":    err.Error(),
-			}).Error("Error estimating gas.")
-			// Should return here because the transaction will fail eventually
-			http.Error(w, "error estimating gas: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), code)
 			return
 		}
+		owner, nonces := sender.address, sender.nonces
 
-		nonce, err := client.PendingNonceAt(ctx, owner)
+		req, code, err := prepareTransaction(ctx, client, oracle, owner, p)
 		if err != nil {
-			log.WithFields(log.Fields{
-				"Nonce":    nonce,
-				"To":       p.To,
-				"Value":    value.String(),
-				"Gas":      gas,
-				"GasPrice": gp.String(),
-				"error":    err.Error(),
-			}).Error("Error getting pending nonce.")
-			http.Error(w, "error getting pending nonce: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), code)
 			return
 		}
+		to, value, fees, gas, data := req.to, req.value, req.fees, req.gas, req.data
 
-		var tx *types.Transaction
-		if to != nil {
-			tx = types.NewTransaction(nonce, *to, value, gas, gp, data)
-		} else {
-			tx = types.NewContractCreation(nonce, big.NewInt(0), gas, gp, data)
-		}
+		nonce := nonces.Acquire()
+
+		tx := whitelist.NewTransaction(signer.ChainID(), nonce, to, value, gas, fees, data)
 
-		valid, err := validate(rules, tx)
+		decision, err := sender.scope(callerRules(r, rules)).Validate(tx, owner, signer.ChainID(), ruleCaller(r))
 		if err != nil {
 			log.WithFields(log.Fields{
+				"From":     owner.Hex(),
 				"Nonce":    nonce,
 				"To":       p.To,
 				"Value":    value.String(),
 				"Gas":      gas,
-				"GasPrice": gp.String(),
+				"GasPrice": bigString(fees.GasPrice),
+				"MaxFee":   bigString(fees.GasFeeCap),
+				"TipCap":   bigString(fees.GasTipCap),
 				"error":    err.Error(),
 			}).Error("Error validating transaction.")
+			nonces.Release(nonce)
+			go sender.fillGaps(context.Background(), client, signer)
 			http.Error(w, "error validating transaction: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		if !valid {
+		if !decision.Allowed {
 			log.WithFields(log.Fields{
+				"From":     owner.Hex(),
 				"Nonce":    nonce,
 				"To":       p.To,
 				"Value":    value.String(),
 				"Gas":      gas,
-				"GasPrice": gp.String(),
+				"GasPrice": bigString(fees.GasPrice),
+				"MaxFee":   bigString(fees.GasFeeCap),
+				"TipCap":   bigString(fees.GasTipCap),
 				"Hash":     tx.Hash().String(),
+				"Code":     decision.Code,
+				"Message":  decision.Message,
+				"APIKey":   callerID(r),
 			}).Warning("Forbidden transaction")
-			http.Error(w, "forbidden transaction", http.StatusForbidden)
+			nonces.Release(nonce)
+			go sender.fillGaps(context.Background(), client, signer)
+			forbidden(w, decision)
 			return
 		}
 
-		signedTx, err := types.SignTx(tx, signer, key)
+		signedTx, err := sender.signer.SignTx(ctx, tx, signer.ChainID())
 		if err != nil {
 			// DO NOT log signedTx
 			log.WithFields(log.Fields{
+				"From":     owner.Hex(),
 				"Nonce":    nonce,
 				"To":       p.To,
 				"Value":    value.String(),
 				"Gas":      gas,
-				"GasPrice": gp.String(),
-				"Hash":     signedTx.Hash().String(),
+				"GasPrice": bigString(fees.GasPrice),
+				"MaxFee":   bigString(fees.GasFeeCap),
+				"TipCap":   bigString(fees.GasTipCap),
+				"Hash":     tx.Hash().String(),
 				"error":    err.Error(),
-			}).Error("Error types.SignTx.")
+			}).Error("Error signing the transaction.")
+			nonces.Release(nonce)
+			go sender.fillGaps(context.Background(), client, signer)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -160,37 +150,151 @@ func txHandler(
 		if err != nil {
 			// DO NOT log signedTx
 			log.WithFields(log.Fields{
+				"From":     owner.Hex(),
 				"Nonce":    nonce,
 				"To":       p.To,
 				"Value":    value.String(),
 				"Gas":      gas,
-				"GasPrice": gp.String(),
+				"GasPrice": bigString(fees.GasPrice),
+				"MaxFee":   bigString(fees.GasFeeCap),
+				"TipCap":   bigString(fees.GasTipCap),
 				"Hash":     signedTx.Hash().String(),
 				"error":    err.Error(),
 			}).Error("Error client.sendTransaction.")
+			if err := nonces.Failed(ctx, nonce, err); err != nil {
+				log.WithFields(log.Fields{
+					"Nonce": nonce,
+					"error": err.Error(),
+				}).Error("Error syncing nonce.")
+			}
+			go sender.fillGaps(context.Background(), client, signer)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		db.Create(&transaction{
-			Nonce:    nonce,
-			To:       p.To,
-			Value:    value.String(),
-			Gas:      gas,
-			GasPrice: gp.String(),
-			Data:     p.Data,
-			Hash:     signedTx.Hash().String(),
+			Type:       signedTx.Type(),
+			From:       owner.Hex(),
+			Nonce:      nonce,
+			To:         addressString(to),
+			Value:      value.String(),
+			Gas:        gas,
+			GasPrice:   bigString(fees.GasPrice),
+			Data:       p.Data,
+			Hash:       signedTx.Hash().String(),
+			Status:     txStatusPending,
+			APIKey:     callerID(r),
+			ClientCert: clientCertID(r),
+
+			MaxFeePerGas:         bigString(fees.GasFeeCap),
+			MaxPriorityFeePerGas: bigString(fees.GasTipCap),
+
+			BroadcastAt: time.Now(),
 		})
 
 		log.WithFields(log.Fields{
+			"From":     owner.Hex(),
 			"Nonce":    nonce,
 			"To":       p.To,
 			"Value":    value.String(),
 			"Gas":      gas,
-			"GasPrice": gp.String(),
+			"GasPrice": bigString(fees.GasPrice),
+			"MaxFee":   bigString(fees.GasFeeCap),
+			"TipCap":   bigString(fees.GasTipCap),
 			"Hash":     signedTx.Hash().String(),
+			"APIKey":   callerID(r),
 		}).Info("Successfully forwared transaction")
 
+		// Let the caller know which fees were picked
+		if fees.Dynamic() {
+			w.Header().Set("X-Max-Fee-Per-Gas", fees.GasFeeCap.String())
+			w.Header().Set("X-Max-Priority-Fee-Per-Gas", fees.GasTipCap.String())
+		} else {
+			w.Header().Set("X-Gas-Price", fees.GasPrice.String())
+		}
 		w.Write([]byte(signedTx.Hash().String()))
 	}
 }
+
+// txRequest is a transaction to sign, before its nonce is known
+type txRequest struct {
+	to    *common.Address
+	value *big.Int
+	fees  whitelist.Fees
+	gas   uint64
+	data  []byte
+}
+
+// prepareTransaction parses a payload, completes the fees and estimates the
+// gas. On error it returns the matching HTTP status code.
+func prepareTransaction(
+	ctx context.Context,
+	client Client,
+	oracle feeOracle,
+	owner common.Address,
+	p sss.TxPayload,
+) (txRequest, int, error) {
+	var to *common.Address
+	if p.To != "" {
+		address := common.HexToAddress(p.To)
+		to = &address
+	}
+
+	value, err := whitelist.ParseValue(p.Value)
+	if err != nil {
+		return txRequest{}, http.StatusBadRequest, err
+	}
+
+	fees, err := whitelist.ParseFees(p.GasPrice, p.MaxFeePerGas, p.MaxPriorityFeePerGas)
+	if err != nil {
+		return txRequest{}, http.StatusBadRequest, err
+	}
+	err = fillFees(ctx, client, oracle, &fees)
+	if err == errNoBaseFee {
+		return txRequest{}, http.StatusBadRequest, err
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"To":    p.To,
+			"error": err.Error(),
+		}).Error("Error suggesting fees.")
+		return txRequest{}, http.StatusInternalServerError, errors.New("error suggesting fees: " + err.Error())
+	}
+
+	data := common.Hex2Bytes(p.Data)
+
+	gas, err := client.EstimateGas(ctx, ethereum.CallMsg{
+		From:      owner,
+		To:        to,
+		GasFeeCap: fees.GasFeeCap,
+		GasTipCap: fees.GasTipCap,
+		Value:     value,
+		Data:      data,
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"To":       p.To,
+			"Value":    value.String(),
+			"Gas":      gas,
+			"GasPrice": bigString(fees.GasPrice),
+			"MaxFee":   bigString(fees.GasFeeCap),
+			"TipCap":   bigString(fees.GasTipCap),
+			"error":    err.Error(),
+		}).Error("Error estimating gas.")
+		// Should stop here because the transaction will fail eventually
+		return txRequest{}, http.StatusInternalServerError, errors.New("error estimating gas: " + err.Error())
+	}
+
+	return txRequest{to: to, value: value, fees: fees, gas: gas, data: data}, http.StatusOK, nil
+}
+
+// forbidden answers a 403 with the reason the rules gave
+func forbidden(w http.ResponseWriter, d whitelist.Decision) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(sss.Denial{
+		Error:   "forbidden transaction",
+		Code:    d.Code,
+		Message: d.Message,
+	})
+}
@@ -1,14 +1,332 @@
 package main
 
-import "github.com/jinzhu/gorm"
+import (
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+	"github.com/WeTrustPlatform/secure-signing-serv/whitelist"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+)
+
+// Lifecycle of a transaction as seen by the receipt watcher
+const (
+	txStatusPending = "pending" // broadcasted, not mined yet
+	txStatusMined   = "mined"   // mined and successful
+	txStatusFailed  = "failed"  // mined but reverted
+	txStatusDropped = "dropped" // nonce used by another transaction
+)
 
 type transaction struct {
 	gorm.Model
+	Type     uint8
+	From     string `gorm:"index"` // signing account
 	Nonce    uint64
 	To       string
 	Value    string
 	Gas      uint64
-	GasPrice string
+	GasPrice string // legacy transactions only
 	Data     string
-	Hash     string `gorm:"unique_index"`
+
+	// Who sent it
+	APIKey     string `gorm:"index"` // ID of the API key or token subject
+	ClientCert string // identity of the TLS client certificate
+
+	// EIP-1559 transactions only
+	MaxFeePerGas         string
+	MaxPriorityFeePerGas string
+
+	Hash        string `gorm:"unique_index"`
+	Status      string `gorm:"index"`
+	BlockNumber uint64
+	BlockHash   string
+	GasUsed     uint64
+	Fee         string // gasUsed * effective gas price, in wei
+
+	// When the current hash was broadcasted. The block is filled by the
+	// rebroadcaster when it first sees the transaction pending.
+	BroadcastAt    time.Time
+	BroadcastBlock uint64
+}
+
+// txHash keeps the hashes a transaction had before being replaced, so it can
+// still be found by them
+type txHash struct {
+	gorm.Model
+	TransactionID uint   `gorm:"index"`
+	Hash          string `gorm:"unique_index"`
+}
+
+// txFilter selects transactions when listing them. Zero values don't filter.
+type txFilter struct {
+	From             string
+	To               string
+	Status           string
+	MinNonce         *uint64
+	MaxNonce         *uint64
+	CreatedAfter     time.Time
+	CreatedBefore    time.Time
+	ContractCreation bool
+	APIKey           string // only return the transactions of this caller
+	Cursor           uint   // only return rows older than this ID
+	Limit            int
+}
+
+// addressString is the checksummed form of an address recorded on a row,
+// empty for a contract creation
+func addressString(a *common.Address) string {
+	if a == nil {
+		return ""
+	}
+	return a.Hex()
+}
+
+// addressSpellings are the lowercase forms an address may be recorded with.
+// Rows used to keep the address as the request spelled it, maybe without 0x.
+func addressSpellings(address string) []string {
+	if !common.IsHexAddress(address) {
+		return []string{strings.ToLower(address)}
+	}
+	a := strings.ToLower(common.HexToAddress(address).Hex())
+	return []string{a, strings.TrimPrefix(a, "0x")}
+}
+
+// addSpending counts a transaction. A mined one counts its value and the gas
+// it used, a pending one its value and its gas limit. A failed one only
+// counts the gas it used, and a dropped one, replaced by another transaction
+// of the nonce, doesn't count.
+func addSpending(s *whitelist.Spending, t transaction) {
+	switch t.Status {
+	case txStatusDropped:
+		return
+	case txStatusFailed:
+		s.Gas += t.GasUsed
+		return
+	case txStatusMined:
+		s.Gas += t.GasUsed
+	default:
+		s.Gas += t.Gas
+	}
+	value, ok := big.NewInt(0).SetString(t.Value, 10)
+	if ok {
+		s.Value.Add(s.Value, value)
+	}
+	s.Count++
+}
+
+// transactionJSON converts a row to its API representation
+func transactionJSON(t transaction, previousHashes []string) sss.Transaction {
+	status := t.Status
+	if status == "" {
+		status = txStatusPending
+	}
+	if previousHashes == nil {
+		previousHashes = []string{}
+	}
+	return sss.Transaction{
+		Type:                 t.Type,
+		From:                 t.From,
+		Nonce:                t.Nonce,
+		To:                   t.To,
+		Value:                t.Value,
+		Gas:                  t.Gas,
+		GasPrice:             t.GasPrice,
+		MaxFeePerGas:         t.MaxFeePerGas,
+		MaxPriorityFeePerGas: t.MaxPriorityFeePerGas,
+		Data:                 t.Data,
+		APIKey:               t.APIKey,
+		ClientCert:           t.ClientCert,
+		Hash:                 t.Hash,
+		PreviousHashes:       previousHashes,
+		Status:               status,
+		BlockNumber:          t.BlockNumber,
+		BlockHash:            t.BlockHash,
+		GasUsed:              t.GasUsed,
+		Fee:                  t.Fee,
+		CreatedAt:            t.CreatedAt,
+	}
+}
+
+// gormRecorder is the Recorder backed by the database
+type gormRecorder struct {
+	*gorm.DB
+}
+
+// AssignSender records the account as the sender of the transactions
+// recorded before there were several accounts
+func (r gormRecorder) AssignSender(from common.Address) error {
+	return r.Model(&transaction{}).Where(`"from" = '' OR "from" IS NULL`).Update("from", from.Hex()).Error
+}
+
+// SetBroadcastBlock records the block a transaction was first seen pending
+// at, unless it was replaced since
+func (r gormRecorder) SetBroadcastBlock(id uint, hash string, block uint64) error {
+	return r.Model(&transaction{}).Where("id = ? AND hash = ?", id, hash).UpdateColumn("broadcast_block", block).Error
+}
+
+// RecordOutcome records whether a transaction was mined, reverted or dropped,
+// and which of its hashes, unless it was replaced since it had hash
+func (r gormRecorder) RecordOutcome(t transaction, hash string) error {
+	return r.Model(&transaction{}).Where("id = ? AND hash = ?", t.ID, hash).Updates(map[string]interface{}{
+		"hash":                     t.Hash,
+		"gas_price":                t.GasPrice,
+		"max_fee_per_gas":          t.MaxFeePerGas,
+		"max_priority_fee_per_gas": t.MaxPriorityFeePerGas,
+		"status":                   t.Status,
+		"block_number":             t.BlockNumber,
+		"block_hash":               t.BlockHash,
+		"gas_used":                 t.GasUsed,
+		"fee":                      t.Fee,
+	}).Error
+}
+
+// RecordReplacement records the hash and fees of the transaction that
+// replaced the one with the replaced hash
+func (r gormRecorder) RecordReplacement(t transaction, replaced string) error {
+	return r.Model(&transaction{}).Where("id = ? AND hash = ?", t.ID, replaced).Updates(map[string]interface{}{
+		"hash":                     t.Hash,
+		"gas_price":                t.GasPrice,
+		"max_fee_per_gas":          t.MaxFeePerGas,
+		"max_priority_fee_per_gas": t.MaxPriorityFeePerGas,
+		"status":                   t.Status,
+		"broadcast_at":             t.BroadcastAt,
+		"broadcast_block":          t.BroadcastBlock,
+	}).Error
+}
+
+// PendingTransactions returns the transactions that are not mined yet, rows
+// recorded before statuses existed included
+func (r gormRecorder) PendingTransactions() ([]transaction, error) {
+	var txs []transaction
+	err := r.Where("status = ? OR status = '' OR status IS NULL", txStatusPending).Order("nonce").Find(&txs).Error
+	return txs, err
+}
+
+// TransactionByHash finds a transaction by its current hash or by the hash of
+// a transaction it replaced
+func (r gormRecorder) TransactionByHash(hash string) (transaction, bool, error) {
+	var t transaction
+	err := r.Where("hash = ?", hash).First(&t).Error
+	if gorm.IsRecordNotFoundError(err) {
+		var h txHash
+		err = r.Where("hash = ?", hash).First(&h).Error
+		if gorm.IsRecordNotFoundError(err) {
+			return t, false, nil
+		}
+		if err != nil {
+			return t, false, err
+		}
+		err = r.First(&t, h.TransactionID).Error
+	}
+	if err != nil {
+		return t, false, err
+	}
+	return t, true, nil
+}
+
+// PreviousHashes returns the hashes a transaction had, oldest first
+func (r gormRecorder) PreviousHashes(id uint) ([]string, error) {
+	var hs []txHash
+	err := r.Where("transaction_id = ?", id).Order("id").Find(&hs).Error
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(hs))
+	for i, h := range hs {
+		hashes[i] = h.Hash
+	}
+	return hashes, nil
+}
+
+// PreviousHashesOf returns the hashes each of the transactions had, oldest
+// first, in one query
+func (r gormRecorder) PreviousHashesOf(ids []uint) (map[uint][]string, error) {
+	hashes := map[uint][]string{}
+	if len(ids) == 0 {
+		return hashes, nil
+	}
+	var hs []txHash
+	err := r.Where("transaction_id IN (?)", ids).Order("id").Find(&hs).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hs {
+		hashes[h.TransactionID] = append(hashes[h.TransactionID], h.Hash)
+	}
+	return hashes, nil
+}
+
+// ReplaceHash records new instead of old among the previous hashes of a
+// transaction
+func (r gormRecorder) ReplaceHash(id uint, old, new string) error {
+	return r.Model(&txHash{}).Where("transaction_id = ? AND hash = ?", id, old).Update("hash", new).Error
+}
+
+// ListTransactions returns the transactions matching the filter, newest first
+func (r gormRecorder) ListTransactions(f txFilter) ([]transaction, error) {
+	q := r.DB
+	if f.From != "" {
+		q = q.Where(`LOWER("from") = ?`, strings.ToLower(f.From))
+	}
+	if f.To != "" {
+		q = q.Where(`LOWER("to") IN (?)`, addressSpellings(f.To))
+	}
+	if f.APIKey != "" {
+		q = q.Where("api_key = ?", f.APIKey)
+	}
+	if f.Status == txStatusPending {
+		q = q.Where("status = ? OR status = '' OR status IS NULL", txStatusPending)
+	} else if f.Status != "" {
+		q = q.Where("status = ?", f.Status)
+	}
+	if f.MinNonce != nil {
+		q = q.Where("nonce >= ?", *f.MinNonce)
+	}
+	if f.MaxNonce != nil {
+		q = q.Where("nonce <= ?", *f.MaxNonce)
+	}
+	if !f.CreatedAfter.IsZero() {
+		q = q.Where("created_at >= ?", f.CreatedAfter)
+	}
+	if !f.CreatedBefore.IsZero() {
+		q = q.Where("created_at < ?", f.CreatedBefore)
+	}
+	if f.ContractCreation {
+		q = q.Where(`"to" = ''`)
+	}
+	if f.Cursor != 0 {
+		q = q.Where("id < ?", f.Cursor)
+	}
+
+	var txs []transaction
+	err := q.Order("id desc").Limit(f.Limit).Find(&txs).Error
+	return txs, err
+}
+
+// Spending sums up the transactions matching the filter. The amounts are
+// strings in the database, so they are added up here.
+func (r gormRecorder) Spending(f whitelist.SpendingFilter) (whitelist.Spending, error) {
+	q := r.DB.Model(&transaction{}).Where("created_at >= ?", f.Since)
+	if f.From != "" {
+		q = q.Where(`LOWER("from") = ?`, strings.ToLower(f.From))
+	}
+	if f.To != "" {
+		q = q.Where(`LOWER("to") IN (?)`, addressSpellings(f.To))
+	}
+	if f.APIKey != "" {
+		q = q.Where("api_key = ?", f.APIKey)
+	}
+	if f.ContractCreation {
+		q = q.Where(`"to" = ''`)
+	}
+
+	var txs []transaction
+	err := q.Select(`value, gas, gas_used, status`).Find(&txs).Error
+	s := whitelist.Spending{Value: big.NewInt(0)}
+	for _, t := range txs {
+		addSpending(&s, t)
+	}
+	return s, err
 }
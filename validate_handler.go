@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+	"github.com/WeTrustPlatform/secure-signing-serv/whitelist"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// validateHandler runs a transaction through the same steps as txHandler up
+// to the rules, and returns the verdict. Nothing is signed or sent, and no
+// nonce is reserved.
+func validateHandler(
+	client Client,
+	signer types.Signer,
+	oracle feeOracle,
+	rules whitelist.Rules,
+	accounts keyring,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.Background()
+
+		decoder := json.NewDecoder(r.Body)
+		var p sss.TxPayload
+		err := decoder.Decode(&p)
+		if err != nil {
+			http.Error(w, "error decoding payload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		sender, code, err := accounts.pick(ctx, client, r, p)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		owner := sender.address
+
+		req, code, err := prepareTransaction(ctx, client, oracle, owner, p)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+
+		nonce := sender.nonces.Peek()
+		tx := whitelist.NewTransaction(signer.ChainID(), nonce, req.to, req.value, req.gas, req.fees, req.data)
+
+		verdict := sss.Validation{
+			From:                 owner.Hex(),
+			Nonce:                nonce,
+			Gas:                  req.gas,
+			GasPrice:             bigString(req.fees.GasPrice),
+			MaxFeePerGas:         bigString(req.fees.GasFeeCap),
+			MaxPriorityFeePerGas: bigString(req.fees.GasTipCap),
+		}
+		d, err := sender.scope(callerRules(r, rules)).Validate(tx, owner, signer.ChainID(), ruleCaller(r))
+		if err != nil {
+			verdict.Error = err.Error()
+		}
+		verdict.Valid = d.Allowed
+		verdict.Code = d.Code
+		verdict.Message = d.Message
+
+		log.WithFields(log.Fields{
+			"From":     owner.Hex(),
+			"Nonce":    nonce,
+			"To":       p.To,
+			"Value":    req.value.String(),
+			"Gas":      req.gas,
+			"GasPrice": bigString(req.fees.GasPrice),
+			"MaxFee":   bigString(req.fees.GasFeeCap),
+			"TipCap":   bigString(req.fees.GasTipCap),
+			"Valid":    verdict.Valid,
+			"Code":     d.Code,
+			"Message":  d.Message,
+		}).Info("Validated transaction")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(verdict)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/WeTrustPlatform/secure-signing-serv/sss"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func Test_validateHandler(t *testing.T) {
+	ctx := context.Background()
+
+	ownerKey, _ := crypto.GenerateKey()
+	owner := bind.NewKeyedTransactor(ownerKey)
+
+	testerKey, _ := crypto.GenerateKey()
+	tester := bind.NewKeyedTransactor(testerKey)
+
+	signer := types.HomesteadSigner{}
+
+	dryRun := func(t *testing.T, rules string, value string) (sss.Validation, *backends.SimulatedBackend, *nonceManager, *dbMock) {
+		client := backends.NewSimulatedBackend(core.GenesisAlloc{
+			owner.From: core.GenesisAccount{Balance: big.NewInt(1000000000000000000)},
+		}, 4000000)
+		db := &dbMock{}
+		accounts, err := newKeyring(ctx, client, db, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p := sss.TxPayload{To: tester.From.Hex(), Value: value, GasPrice: "1000000000"}
+		b := new(bytes.Buffer)
+		json.NewEncoder(b).Encode(p)
+		req, err := http.NewRequest("POST", "/v1/proxy/transactions:validate", b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		validateHandler(client, signer, nil, newTestValidator(t, rules), accounts).ServeHTTP(rr, req)
+		if rr.Code != 200 {
+			t.Fatalf("response code = %v, want %v", rr.Code, 200)
+		}
+
+		var got sss.Validation
+		err = json.NewDecoder(rr.Body).Decode(&got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got, client, accounts[0].nonces, db
+	}
+
+	t.Run("Returns the verdict without sending", func(t *testing.T) {
+		got, client, nonces, db := dryRun(t, `function validate(tx) return tx.value == "1" end`, "1")
+
+		if !got.Valid || got.Error != "" {
+			t.Errorf("verdict = %+v, want valid", got)
+		}
+		if got.Gas != 21000 || got.Nonce != 0 || got.GasPrice != "1000000000" || got.From != owner.From.Hex() {
+			t.Errorf("verdict = %+v", got)
+		}
+
+		if n, _ := client.PendingNonceAt(ctx, owner.From); n != 0 {
+			t.Errorf("pending nonce = %v, want %v", n, 0)
+		}
+		if len(db.txs) != 0 {
+			t.Errorf("recorded transactions = %v, want %v", len(db.txs), 0)
+		}
+		if n := nonces.Acquire(); n != 0 {
+			t.Errorf("next nonce = %v, want %v", n, 0)
+		}
+	})
+
+	t.Run("Returns a refusal", func(t *testing.T) {
+		got, _, _, _ := dryRun(t, `function validate(tx) return tx.value == "1" end`, "2")
+
+		if got.Valid || got.Error != "" {
+			t.Errorf("verdict = %+v, want refused", got)
+		}
+	})
+
+	t.Run("Returns the error of the rules", func(t *testing.T) {
+		got, _, _, _ := dryRun(t, `function validate(tx) return tx.missing.field end`, "1")
+
+		if got.Valid || got.Error == "" {
+			t.Errorf("verdict = %+v, want an error", got)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// vaultSigner has the transaction hashes signed by a secp256k1 key of a
+// Vault transit-style secrets engine, with POST <endpoint>/sign/<key>. The
+// key never leaves Vault.
+type vaultSigner struct {
+	address  common.Address
+	endpoint string // the mount of the engine, like https://vault:8200/v1/transit
+	key      string
+	token    string
+	client   http.Client
+}
+
+func newVaultSigner(endpoint, key, token string, address common.Address) *vaultSigner {
+	return &vaultSigner{
+		address:  address,
+		endpoint: strings.TrimRight(endpoint, "/"),
+		key:      key,
+		token:    token,
+		client:   http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *vaultSigner) SignTx(ctx context.Context, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	signer := types.LatestSignerForChainID(chainID)
+	hash := signer.Hash(tx)
+	sig, err := s.sign(ctx, hash.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	signed, err := tx.WithSignature(signer, sig)
+	if err != nil {
+		return nil, err
+	}
+	err = checkSigned(tx, signed, chainID, s.address)
+	if err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+func (s *vaultSigner) Address() common.Address {
+	return s.address
+}
+
+// sign returns the [R || S || V] signature of a hash
+func (s *vaultSigner) sign(ctx context.Context, hash []byte) ([]byte, error) {
+	b, err := json.Marshal(map[string]interface{}{
+		"input":     base64.StdEncoding.EncodeToString(hash),
+		"prehashed": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", s.endpoint+"/sign/"+s.key, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", s.token)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		Data struct {
+			Signature string `json:"signature"`
+		} `json:"data"`
+		Errors []string `json:"errors"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if resp.StatusCode != http.StatusOK {
+		if err == nil && len(res.Errors) > 0 {
+			return nil, fmt.Errorf("error from vault: %v", strings.Join(res.Errors, ", "))
+		}
+		return nil, fmt.Errorf("error from vault: %v", resp.Status)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.recoverable(hash, res.Data.Signature)
+}
+
+// recoverable turns a vault:v<version>:<base64> signature, raw [R || S] or
+// [R || S || V] or ASN.1 DER, into the [R || S || V] signature of the key of
+// the account, with a low S as Ethereum wants
+func (s *vaultSigner) recoverable(hash []byte, signature string) ([]byte, error) {
+	parts := strings.Split(signature, ":")
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, errors.New("invalid vault signature")
+	}
+	b, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("invalid vault signature")
+	}
+
+	var r, sv *big.Int
+	switch len(b) {
+	case 64, 65:
+		r, sv = new(big.Int).SetBytes(b[:32]), new(big.Int).SetBytes(b[32:64])
+	default:
+		var der struct{ R, S *big.Int }
+		rest, err := asn1.Unmarshal(b, &der)
+		if err != nil || len(rest) > 0 {
+			return nil, errors.New("invalid vault signature")
+		}
+		r, sv = der.R, der.S
+	}
+	n := crypto.S256().Params().N
+	if r.Sign() <= 0 || sv.Sign() <= 0 || r.Cmp(n) >= 0 || sv.Cmp(n) >= 0 {
+		return nil, errors.New("invalid vault signature")
+	}
+	if sv.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		sv = new(big.Int).Sub(n, sv)
+	}
+
+	sig := make([]byte, 65)
+	r.FillBytes(sig[:32])
+	sv.FillBytes(sig[32:64])
+	for v := byte(0); v < 2; v++ {
+		sig[64] = v
+		pub, err := crypto.SigToPub(hash, sig)
+		if err == nil && crypto.PubkeyToAddress(*pub) == s.address {
+			return sig, nil
+		}
+	}
+	return nil, errors.New("vault key " + s.key + " is not the key of " + s.address.Hex())
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// newVaultMock serves POST /v1/transit/sign/<name> for the named keys, with
+// DER signatures like Vault or raw ones
+func newVaultMock(token string, keys map[string]*ecdsa.PrivateKey, der bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		key, ok := keys[r.URL.Path[len("/v1/transit/sign/"):]]
+		if r.Method != "POST" || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		var body struct {
+			Input     string `json:"input"`
+			Prehashed bool   `json:"prehashed"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		hash, err := base64.StdEncoding.DecodeString(body.Input)
+		if err != nil || !body.Prehashed {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors":["invalid input"]}`))
+			return
+		}
+		sig, err := crypto.Sign(hash, key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if der {
+			// Vault may return either S, send the high one
+			s := new(big.Int).SetBytes(sig[32:64])
+			s.Sub(crypto.S256().Params().N, s)
+			sig, _ = asn1.Marshal(struct{ R, S *big.Int }{new(big.Int).SetBytes(sig[:32]), s})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]string{"signature": "vault:v1:" + base64.StdEncoding.EncodeToString(sig)},
+		})
+	}))
+}
+
+func Test_vaultSigner(t *testing.T) {
+	ctx := context.Background()
+
+	ownerKey, _ := crypto.GenerateKey()
+	owner := crypto.PubkeyToAddress(ownerKey.PublicKey)
+	otherKey, _ := crypto.GenerateKey()
+	to := common.HexToAddress("0x5597285BbE81BaF351e2C0884e9a5f4416958862")
+	chainID := big.NewInt(1337)
+	keys := map[string]*ecdsa.PrivateKey{"hot": ownerKey, "other": otherKey}
+
+	t.Run("Signs with a key of Vault", func(t *testing.T) {
+		for _, der := range []bool{true, false} {
+			vault := newVaultMock("s.token", keys, der)
+			s := newVaultSigner(vault.URL+"/v1/transit/", "hot", "s.token", owner)
+
+			for _, tx := range []*types.Transaction{
+				types.NewTransaction(3, to, big.NewInt(1), 21000, big.NewInt(1000000000), nil),
+				types.NewTx(&types.DynamicFeeTx{ChainID: chainID, Nonce: 4, GasTipCap: big.NewInt(1), GasFeeCap: big.NewInt(2), Gas: 21000, To: &to}),
+			} {
+				signed, err := s.SignTx(ctx, tx, chainID)
+				if err != nil {
+					t.Fatal(err)
+					return
+				}
+				sender, err := types.Sender(types.NewLondonSigner(chainID), signed)
+				if sender != owner || signed.Nonce() != tx.Nonce() {
+					t.Errorf("der %v: sender = %v, nonce = %v, want %v, %v (%v)", der, sender.Hex(), signed.Nonce(), owner.Hex(), tx.Nonce(), err)
+				}
+			}
+
+			signed, err := s.SignTx(ctx, types.NewTransaction(0, to, big.NewInt(1), 21000, big.NewInt(1), nil), nil)
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			if signed.Protected() {
+				t.Errorf("der %v: protected = %v, want %v without a chain", der, signed.Protected(), false)
+			}
+			vault.Close()
+		}
+	})
+
+	t.Run("Refuses the errors and the wrong keys", func(t *testing.T) {
+		vault := newVaultMock("s.token", keys, true)
+		defer vault.Close()
+
+		tx := types.NewTransaction(0, to, big.NewInt(1), 21000, big.NewInt(1000000000), nil)
+		for _, s := range []*vaultSigner{
+			newVaultSigner(vault.URL+"/v1/transit", "hot", "wrong", owner),
+			newVaultSigner(vault.URL+"/v1/transit", "missing", "s.token", owner),
+			newVaultSigner(vault.URL+"/v1/transit", "other", "s.token", owner),
+			newVaultSigner("http://127.0.0.1:1/v1/transit", "hot", "s.token", owner),
+		} {
+			if _, err := s.SignTx(ctx, tx, chainID); err == nil {
+				t.Errorf("%v %v: error = %v, want an error", s.endpoint, s.key, err)
+			}
+		}
+
+		s := newVaultSigner(vault.URL, "hot", "s.token", owner)
+		for _, signature := range []string{"", "vault:v1", "vault:v1:!", "other:v1:AAAA", "vault:v1:" + base64.StdEncoding.EncodeToString(make([]byte, 64))} {
+			if _, err := s.recoverable(make([]byte, 32), signature); err == nil {
+				t.Errorf("%q: error = %v, want an error", signature, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package whitelist
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// ABIRegistry holds the ABIs of the known contracts, so the rules can read
+// the method and arguments of the calls instead of the raw data
+type ABIRegistry map[common.Address]abi.ABI
+
+// LoadABIRegistry reads a JSON object mapping contract addresses to their ABI
+func LoadABIRegistry(r io.Reader) (ABIRegistry, error) {
+	var raw map[string]json.RawMessage
+	err := json.NewDecoder(r).Decode(&raw)
+	if err != nil {
+		return nil, err
+	}
+
+	abis := ABIRegistry{}
+	for address, def := range raw {
+		if !common.IsHexAddress(address) {
+			return nil, fmt.Errorf("invalid contract address %v", address)
+		}
+		a, err := abi.JSON(bytes.NewReader(def))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ABI for %v: %v", address, err)
+		}
+		abis[common.HexToAddress(address)] = a
+	}
+	return abis, nil
+}
+
+// decode finds the method called by a transaction and unpacks its arguments.
+// It returns a nil method when the contract or the method is unknown, or when
+// the data can't be decoded.
+func (abis ABIRegistry) decode(tx *types.Transaction) (*abi.Method, []interface{}) {
+	if tx.To() == nil || len(tx.Data()) < 4 {
+		return nil, nil
+	}
+	a, ok := abis[*tx.To()]
+	if !ok {
+		return nil, nil
+	}
+	method, err := a.MethodById(tx.Data()[:4])
+	if err != nil {
+		return nil, nil
+	}
+	args, err := method.Inputs.Unpack(tx.Data()[4:])
+	if err != nil {
+		return nil, nil
+	}
+	return method, args
+}
+
+// argsToLTable exposes decoded arguments by position and by name
+func argsToLTable(L *lua.LState, inputs abi.Arguments, args []interface{}) *lua.LTable {
+	t := L.NewTable()
+	for i, input := range inputs {
+		v := abiToLValue(L, input.Type, reflect.ValueOf(args[i]))
+		t.RawSetInt(i+1, v)
+		if input.Name != "" {
+			L.SetField(t, input.Name, v)
+		}
+	}
+	return t
+}
+
+// abiToLValue converts an unpacked ABI value. Integers are decimal strings,
+// addresses are checksummed and bytes are hex encoded without 0x.
+func abiToLValue(L *lua.LState, t abi.Type, v reflect.Value) lua.LValue {
+	switch t.T {
+	case abi.IntTy, abi.UintTy:
+		return lua.LString(fmt.Sprint(v.Interface()))
+	case abi.BoolTy:
+		return lua.LBool(v.Bool())
+	case abi.StringTy:
+		return lua.LString(v.String())
+	case abi.AddressTy:
+		return lua.LString(v.Interface().(common.Address).String())
+	case abi.BytesTy:
+		return lua.LString(common.Bytes2Hex(v.Bytes()))
+	case abi.FixedBytesTy:
+		b := make([]byte, v.Len())
+		reflect.Copy(reflect.ValueOf(b), v)
+		return lua.LString(common.Bytes2Hex(b))
+	case abi.SliceTy, abi.ArrayTy:
+		a := L.NewTable()
+		for i := 0; i < v.Len(); i++ {
+			a.RawSetInt(i+1, abiToLValue(L, *t.Elem, v.Index(i)))
+		}
+		return a
+	case abi.TupleTy:
+		s := L.NewTable()
+		for i, elem := range t.TupleElems {
+			L.SetField(s, t.TupleRawNames[i], abiToLValue(L, *elem, v.Field(i)))
+		}
+		return s
+	default:
+		return lua.LString(fmt.Sprint(v.Interface()))
+	}
+}
@@ -0,0 +1,110 @@
+package whitelist
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const tokenABIs = `{
+	"0x5597285BbE81BaF351e2C0884e9a5f4416958862": [
+		{"type":"function","name":"transfer","inputs":[{"name":"to","type":"address"},{"name":"amount","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]},
+		{"type":"function","name":"batch","inputs":[
+			{"name":"payments","type":"tuple[]","components":[{"name":"to","type":"address"},{"name":"amount","type":"uint256"}]},
+			{"name":"ref","type":"bytes32"},
+			{"name":"memo","type":"bytes"}
+		],"outputs":[]}
+	]
+}`
+
+func Test_ABIRegistry(t *testing.T) {
+	token := common.HexToAddress("0x5597285BbE81BaF351e2C0884e9a5f4416958862")
+	recipient := common.HexToAddress("0xC7f965a58942dbf4E9fbdf77A511863d7041339d")
+
+	abis, err := LoadABIRegistry(strings.NewReader(tokenABIs))
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	call := func(to common.Address, method string, args ...interface{}) *types.Transaction {
+		a := abis[token]
+		data, err := a.Pack(method, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return types.NewTransaction(1, to, big.NewInt(0), 100000, big.NewInt(1000000000), data)
+	}
+
+	rules := `
+recipients = {["0xC7f965a58942dbf4E9fbdf77A511863d7041339d"] = true}
+
+function validate(tx)
+	return tx.method == "transfer"
+		and recipients[tx.args.to]
+		and bigcmp(tx.args.amount, "1000000000000000000000") < 0
+		and tx.args[2] == tx.args.amount
+end
+`
+
+	t.Run("Can filter on the decoded arguments", func(t *testing.T) {
+		for _, c := range []struct {
+			name string
+			tx   *types.Transaction
+			want bool
+		}{
+			{"small transfer", call(token, "transfer", recipient, big.NewInt(10)), true},
+			{"large transfer", call(token, "transfer", recipient, new(big.Int).Mul(big.NewInt(2000), big.NewInt(1e18))), false},
+			{"unknown recipient", call(token, "transfer", token, big.NewInt(10)), false},
+			{"unknown contract", call(recipient, "transfer", recipient, big.NewInt(10)), false},
+		} {
+			got, err := validate(rules, abis, c.tx, common.Address{}, nil)
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			if got != c.want {
+				t.Errorf("%v: validate = %v, want %v", c.name, got, c.want)
+			}
+		}
+	})
+
+	t.Run("Decodes tuples, arrays and bytes", func(t *testing.T) {
+		payments := []struct {
+			To     common.Address
+			Amount *big.Int
+		}{{recipient, big.NewInt(5)}, {token, big.NewInt(7)}}
+		var ref [32]byte
+		ref[0] = 0xab
+		tx := call(token, "batch", payments, ref, []byte{0x01, 0x02})
+
+		rules := `
+function validate(tx)
+	return tx.method == "batch"
+		and #tx.args.payments == 2
+		and tx.args.payments[1].to == "0xC7f965a58942dbf4E9fbdf77A511863d7041339d"
+		and tx.args.payments[2].amount == "7"
+		and tx.args.ref == "ab00000000000000000000000000000000000000000000000000000000000000"
+		and tx.args.memo == "0102"
+end
+`
+		got, err := validate(rules, abis, tx, common.Address{}, nil)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if got != true {
+			t.Errorf("validate = %v, want %v", got, true)
+		}
+	})
+
+	t.Run("Rejects an invalid address", func(t *testing.T) {
+		_, err := LoadABIRegistry(strings.NewReader(`{"0x1234": []}`))
+		if err == nil {
+			t.Errorf("LoadABIRegistry error = %v, want an error", err)
+		}
+	})
+}
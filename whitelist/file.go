@@ -0,0 +1,103 @@
+package whitelist
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// File holds the rules read from a file. Reload swaps in the new rules
+// only when they compile and pass the samples, otherwise the previous ones
+// stay active.
+type File struct {
+	path     string
+	abis     ABIRegistry
+	db       SpendingReader
+	poolSize int
+	timeout  time.Duration
+	policy   Rules // checked before the rules of the file, with the samples too
+	samples  []Sample
+	from     common.Address
+	chainID  *big.Int
+
+	current atomic.Value // *Validator
+}
+
+// NewFile loads the rules of the file at path, then checks them against the
+// samples, after policy when there is one. Reload does it again.
+func NewFile(
+	path string,
+	abis ABIRegistry,
+	db SpendingReader,
+	poolSize int,
+	timeout time.Duration,
+	policy Rules,
+	samples []Sample,
+	from common.Address,
+	chainID *big.Int,
+) (*File, error) {
+	r := &File{
+		path:     path,
+		abis:     abis,
+		db:       db,
+		poolSize: poolSize,
+		timeout:  timeout,
+		policy:   policy,
+		samples:  samples,
+		from:     from,
+		chainID:  chainID,
+	}
+	err := r.Reload()
+	return r, err
+}
+
+// Validate runs the active rules
+func (r *File) Validate(tx *types.Transaction, from common.Address, chainID *big.Int, caller Caller) (Decision, error) {
+	return r.current.Load().(*Validator).Validate(tx, from, chainID, caller)
+}
+
+// Reload reads the file again and swaps the rules in if they are valid
+func (r *File) Reload() error {
+	source, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Path":  r.path,
+			"error": err.Error(),
+		}).Error("Error reading the rules.")
+		return err
+	}
+	sum := sha256.Sum256(source)
+	hash := hex.EncodeToString(sum[:])
+
+	v, err := NewValidator(string(source), r.abis, r.db, r.poolSize, r.timeout)
+	if err == nil {
+		var rules Rules = v
+		if r.policy != nil {
+			rules = All{r.policy, v}
+		}
+		err = CheckSamples(rules, r.samples, r.from, r.chainID)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Path":  r.path,
+			"Hash":  hash,
+			"error": err.Error(),
+		}).Error("Error loading the rules, keeping the previous ones.")
+		return err
+	}
+
+	r.current.Store(v)
+	log.WithFields(log.Fields{
+		"Path": r.path,
+		"Hash": hash,
+	}).Info("Loaded the rules")
+	return nil
+}
@@ -0,0 +1,100 @@
+package whitelist
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func Test_File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rules.lua")
+
+	samples, err := LoadSamples(strings.NewReader(`[
+		{"to": "0x5597285BbE81BaF351e2C0884e9a5f4416958862", "value": "1", "valid": true},
+		{"to": "0xC7f965a58942dbf4E9fbdf77A511863d7041339d", "value": "1", "valid": false}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	write := func(rules string) {
+		err := ioutil.WriteFile(path, []byte(rules), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	allows := func(r Rules, to string) bool {
+		tx := types.NewTransaction(0, common.HexToAddress(to), big.NewInt(1), 21000, big.NewInt(1), nil)
+		d, err := r.Validate(tx, common.Address{}, big.NewInt(1), Caller{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d.Allowed
+	}
+
+	write(`function validate(tx) return tx.to == "0x5597285BbE81BaF351e2C0884e9a5f4416958862" end`)
+	rules, err := NewFile(path, nil, nil, 1, DefaultTimeout, nil, samples, common.Address{}, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	t.Run("Keeps the previous rules when the samples fail", func(t *testing.T) {
+		write(`function validate(tx) return true end`)
+		if err := rules.Reload(); err == nil {
+			t.Errorf("reload error = %v, want an error", err)
+		}
+		if allows(rules, "0xC7f965a58942dbf4E9fbdf77A511863d7041339d") {
+			t.Errorf("the new rules were swapped in")
+		}
+	})
+
+	t.Run("Keeps the previous rules when they don't compile", func(t *testing.T) {
+		write(`function validate(tx) return`)
+		if err := rules.Reload(); err == nil {
+			t.Errorf("reload error = %v, want an error", err)
+		}
+		if !allows(rules, "0x5597285BbE81BaF351e2C0884e9a5f4416958862") {
+			t.Errorf("the new rules were swapped in")
+		}
+	})
+
+	t.Run("Checks the samples with the policy", func(t *testing.T) {
+		policy, err := LoadPolicy(strings.NewReader(`to: ["0x5597285BbE81BaF351e2C0884e9a5f4416958862"]`))
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		write(`function validate(tx) return true end`)
+		if _, err := NewFile(path, nil, nil, 1, DefaultTimeout, policy, samples, common.Address{}, big.NewInt(1)); err != nil {
+			t.Errorf("error = %v, want the policy to refuse the second sample", err)
+		}
+		if _, err := NewFile(path, nil, nil, 1, DefaultTimeout, nil, samples, common.Address{}, big.NewInt(1)); err == nil {
+			t.Errorf("error = %v, want an error without the policy", err)
+		}
+	})
+
+	t.Run("Swaps in valid rules", func(t *testing.T) {
+		write(`function validate(tx) return tx.to ~= "0xC7f965a58942dbf4E9fbdf77A511863d7041339d" end`)
+		if err := rules.Reload(); err != nil {
+			t.Fatal(err)
+			return
+		}
+		if !allows(rules, "0x0000000000000000000000000000000000000001") {
+			t.Errorf("the new rules weren't swapped in")
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package whitelist
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"gopkg.in/yaml.v2"
+)
+
+// Policy is a declarative whitelist, written in YAML or JSON. Empty fields
+// don't restrict, except contractCreation which defaults to false.
+type Policy struct {
+	To               []string            `yaml:"to"`               // allowed destinations
+	Methods          map[string][]string `yaml:"methods"`          // allowed selectors or signatures per contract
+	MaxValue         string              `yaml:"maxValue"`         // in wei
+	MaxGas           uint64              `yaml:"maxGas"`           // gas limit
+	MaxGasPrice      string              `yaml:"maxGasPrice"`      // in wei, the fee cap of EIP-1559 transactions
+	ContractCreation bool                `yaml:"contractCreation"` // allow deployments
+
+	to          map[common.Address]bool
+	methods     map[common.Address]map[[4]byte]bool
+	maxValue    *big.Int
+	maxGasPrice *big.Int
+}
+
+// LoadPolicy reads and checks a policy. Unknown fields are refused so a typo
+// doesn't silently lift a restriction.
+func LoadPolicy(r io.Reader) (*Policy, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	err = yaml.UnmarshalStrict(b, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(p.To) > 0 {
+		p.to = map[common.Address]bool{}
+		for _, address := range p.To {
+			if !common.IsHexAddress(address) {
+				return nil, fmt.Errorf("invalid address in to: %v", address)
+			}
+			p.to[common.HexToAddress(address)] = true
+		}
+	}
+
+	p.methods = map[common.Address]map[[4]byte]bool{}
+	for address, methods := range p.Methods {
+		if !common.IsHexAddress(address) {
+			return nil, fmt.Errorf("invalid address in methods: %v", address)
+		}
+		selectors := map[[4]byte]bool{}
+		for _, method := range methods {
+			selector, err := parseSelector(method)
+			if err != nil {
+				return nil, err
+			}
+			selectors[selector] = true
+		}
+		p.methods[common.HexToAddress(address)] = selectors
+	}
+
+	var ok bool
+	if p.MaxValue != "" {
+		p.maxValue, ok = big.NewInt(0).SetString(p.MaxValue, 10)
+		if !ok {
+			return nil, errors.New("couldn't convert maxValue to big.Int")
+		}
+	}
+	if p.MaxGasPrice != "" {
+		p.maxGasPrice, ok = big.NewInt(0).SetString(p.MaxGasPrice, 10)
+		if !ok {
+			return nil, errors.New("couldn't convert maxGasPrice to big.Int")
+		}
+	}
+	return p, nil
+}
+
+// parseSelector reads a 4-byte selector in hex, or computes it from a method
+// signature like transfer(address,uint256)
+func parseSelector(method string) ([4]byte, error) {
+	var selector [4]byte
+	if strings.Contains(method, "(") {
+		copy(selector[:], crypto.Keccak256([]byte(method))[:4])
+		return selector, nil
+	}
+	if !strings.HasPrefix(method, "0x") {
+		method = "0x" + method
+	}
+	b, err := hexutil.Decode(method)
+	if err != nil || len(b) != 4 {
+		return selector, fmt.Errorf("invalid method selector: %v", method)
+	}
+	copy(selector[:], b)
+	return selector, nil
+}
+
+// Validate checks a transaction against the policy
+func (p *Policy) Validate(tx *types.Transaction, from common.Address, chainID *big.Int, caller Caller) (Decision, error) {
+	if tx.To() == nil {
+		if !p.ContractCreation {
+			return Decision{Code: "policy.contractCreation", Message: "contract creation isn't allowed"}, nil
+		}
+	} else {
+		if p.to != nil && !p.to[*tx.To()] {
+			return Decision{Code: "policy.to", Message: "destination isn't allowed"}, nil
+		}
+		if selectors, ok := p.methods[*tx.To()]; ok {
+			var selector [4]byte
+			if len(tx.Data()) < 4 {
+				return Decision{Code: "policy.methods", Message: "calls to this contract need a method"}, nil
+			}
+			copy(selector[:], tx.Data()[:4])
+			if !selectors[selector] {
+				return Decision{Code: "policy.methods", Message: "method isn't allowed"}, nil
+			}
+		}
+	}
+
+	if p.maxValue != nil && tx.Value().Cmp(p.maxValue) > 0 {
+		return Decision{Code: "policy.maxValue", Message: "value above " + p.maxValue.String()}, nil
+	}
+	if p.MaxGas != 0 && tx.Gas() > p.MaxGas {
+		return Decision{Code: "policy.maxGas", Message: fmt.Sprintf("gas above %v", p.MaxGas)}, nil
+	}
+	// GasFeeCap is the gas price of legacy transactions
+	if p.maxGasPrice != nil && tx.GasFeeCap().Cmp(p.maxGasPrice) > 0 {
+		return Decision{Code: "policy.maxGasPrice", Message: "gas price above " + p.maxGasPrice.String()}, nil
+	}
+
+	return Decision{Allowed: true}, nil
+}
@@ -0,0 +1,129 @@
+package whitelist
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func Test_policy(t *testing.T) {
+	token := common.HexToAddress("0x5597285BbE81BaF351e2C0884e9a5f4416958862")
+	wallet := common.HexToAddress("0xC7f965a58942dbf4E9fbdf77A511863d7041339d")
+	other := common.HexToAddress("0x0000000000000000000000000000000000000001")
+
+	yamlPolicy := `
+to:
+  - "0x5597285BbE81BaF351e2C0884e9a5f4416958862"
+  - "0xc7f965a58942dbf4e9fbdf77a511863d7041339d"
+methods:
+  "0x5597285BbE81BaF351e2C0884e9a5f4416958862":
+    - "transfer(address,uint256)"
+    - "0x095ea7b3"
+maxValue: 1000000000000000000
+maxGas: 100000
+maxGasPrice: "100000000000"
+`
+	transfer := common.Hex2Bytes("a9059cbb0000")
+	approve := common.Hex2Bytes("095ea7b30000")
+	mint := common.Hex2Bytes("40c10f190000")
+
+	legacy := func(to *common.Address, value int64, gas uint64, gasPrice int64, data []byte) *types.Transaction {
+		return types.NewTx(&types.LegacyTx{To: to, Value: big.NewInt(value), Gas: gas, GasPrice: big.NewInt(gasPrice), Data: data})
+	}
+
+	t.Run("Checks the transactions", func(t *testing.T) {
+		p, err := LoadPolicy(strings.NewReader(yamlPolicy))
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		for _, c := range []struct {
+			name string
+			tx   *types.Transaction
+			want string
+		}{
+			{"transfer", legacy(&token, 0, 50000, 1, transfer), ""},
+			{"approve", legacy(&token, 0, 50000, 1, approve), ""},
+			{"payment", legacy(&wallet, 1000000000000000000, 21000, 1, nil), ""},
+			{"unknown destination", legacy(&other, 1, 21000, 1, nil), "policy.to"},
+			{"unknown method", legacy(&token, 0, 50000, 1, mint), "policy.methods"},
+			{"no method", legacy(&token, 1, 50000, 1, nil), "policy.methods"},
+			{"value", legacy(&wallet, 1000000000000000001, 21000, 1, nil), "policy.maxValue"},
+			{"gas", legacy(&wallet, 1, 100001, 1, nil), "policy.maxGas"},
+			{"gas price", legacy(&wallet, 1, 21000, 100000000001, nil), "policy.maxGasPrice"},
+			{"fee cap", types.NewTx(&types.DynamicFeeTx{To: &wallet, Value: big.NewInt(1), Gas: 21000, GasFeeCap: big.NewInt(100000000001), GasTipCap: big.NewInt(1)}), "policy.maxGasPrice"},
+			{"contract creation", legacy(nil, 0, 50000, 1, transfer), "policy.contractCreation"},
+		} {
+			got, err := p.Validate(c.tx, common.Address{}, nil, Caller{})
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			if got.Allowed != (c.want == "") || got.Code != c.want {
+				t.Errorf("%v: decision = %+v, want code %q", c.name, got, c.want)
+			}
+		}
+	})
+
+	t.Run("Reads JSON", func(t *testing.T) {
+		p, err := LoadPolicy(strings.NewReader(`{"contractCreation": true, "maxValue": "0"}`))
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		got, _ := p.Validate(legacy(nil, 0, 50000, 1, nil), common.Address{}, nil, Caller{})
+		if !got.Allowed {
+			t.Errorf("decision = %+v, want allowed", got)
+		}
+		got, _ = p.Validate(legacy(&other, 1, 21000, 1, nil), common.Address{}, nil, Caller{})
+		if got.Code != "policy.maxValue" {
+			t.Errorf("decision = %+v, want code %q", got, "policy.maxValue")
+		}
+	})
+
+	t.Run("Refuses invalid policies", func(t *testing.T) {
+		for _, policy := range []string{
+			`maxvalue: "1"`,
+			`to: ["0x1234"]`,
+			`methods: {"0x5597285BbE81BaF351e2C0884e9a5f4416958862": ["a9059c"]}`,
+			`maxValue: "1e18"`,
+			`maxGasPrice: "lots"`,
+		} {
+			_, err := LoadPolicy(strings.NewReader(policy))
+			if err == nil {
+				t.Errorf("%v: LoadPolicy error = %v, want an error", policy, err)
+			}
+		}
+	})
+
+	t.Run("Requires both the policy and the Lua rules", func(t *testing.T) {
+		p, err := LoadPolicy(strings.NewReader(yamlPolicy))
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		rules := All{p, newTestValidator(t, `function validate(tx) return tx.value ~= "7", "no 7" end`)}
+
+		for _, c := range []struct {
+			tx   *types.Transaction
+			want Decision
+		}{
+			{legacy(&wallet, 1, 21000, 1, nil), Decision{Allowed: true, Message: "no 7"}},
+			{legacy(&wallet, 7, 21000, 1, nil), Decision{Message: "no 7"}},
+			{legacy(&other, 1, 21000, 1, nil), Decision{Code: "policy.to", Message: "destination isn't allowed"}},
+		} {
+			got, err := rules.Validate(c.tx, common.Address{}, nil, Caller{})
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			if got != c.want {
+				t.Errorf("decision = %+v, want %+v", got, c.want)
+			}
+		}
+	})
+}
@@ -0,0 +1,72 @@
+// Package whitelist decides which transactions 3S signs, with Lua rules and
+// declarative policies
+package whitelist
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Rules validates transactions. It is implemented by a Validator running Lua,
+// a File reloading a Validator, a Policy, or All of them.
+type Rules interface {
+	Validate(tx *types.Transaction, from common.Address, chainID *big.Int, caller Caller) (Decision, error)
+}
+
+// Caller identifies who asked for a transaction. It is empty for the
+// transactions 3S replaces on its own.
+type Caller struct {
+	ID         string // API key or token subject
+	ClientCert string // identity of the TLS client certificate
+}
+
+// Decision is the verdict of the rules, with the reason of a refusal
+type Decision struct {
+	Allowed bool
+	Code    string
+	Message string
+}
+
+// All accepts a transaction when all its rules do. They run in order and the
+// first refusal or error is returned.
+type All []Rules
+
+// Validate runs the rules in order
+func (all All) Validate(tx *types.Transaction, from common.Address, chainID *big.Int, caller Caller) (Decision, error) {
+	d := Decision{Allowed: true}
+	for _, rules := range all {
+		var err error
+		d, err = rules.Validate(tx, from, chainID, caller)
+		if err != nil || !d.Allowed {
+			return d, err
+		}
+	}
+	return d, nil
+}
+
+// SpendingFilter selects the transactions counted against a spending limit.
+// Zero values don't filter.
+type SpendingFilter struct {
+	Since            time.Time
+	From             string // signing account
+	To               string
+	APIKey           string // caller that sent the transactions
+	ContractCreation bool
+}
+
+// Spending sums up the transactions sent in a window. Only the pending and
+// mined ones count, except for the gas used by the failed ones.
+type Spending struct {
+	Value *big.Int // in wei
+	Gas   uint64   // gas used, gas limits for the pending transactions
+	Count uint64
+}
+
+// SpendingReader answers the spending queries of the rules, usually from the
+// recorded transactions
+type SpendingReader interface {
+	Spending(f SpendingFilter) (Spending, error)
+}
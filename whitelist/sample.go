@@ -0,0 +1,123 @@
+package whitelist
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"gopkg.in/yaml.v2"
+)
+
+// Sample is a transaction the rules must accept or refuse, to check rules
+// before swapping them in or in tests. The fields are those of the POST
+// payload, plus the nonce, the gas and the expected result.
+type Sample struct {
+	Name                 string `json:"name" yaml:"name"` // shown when the sample fails, optional
+	To                   string `json:"to" yaml:"to"`
+	Value                string `json:"value" yaml:"value"`
+	GasPrice             string `json:"gasPrice" yaml:"gasPrice"`
+	MaxFeePerGas         string `json:"maxFeePerGas" yaml:"maxFeePerGas"`
+	MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas" yaml:"maxPriorityFeePerGas"`
+	Data                 string `json:"data" yaml:"data"`
+	Nonce                uint64 `json:"nonce" yaml:"nonce"`
+	Gas                  uint64 `json:"gas" yaml:"gas"`
+	Valid                bool   `json:"valid" yaml:"valid"`
+	Code                 string `json:"code" yaml:"code"`             // expected reason code of a refusal, optional
+	Caller               string `json:"caller" yaml:"caller"`         // API key or token subject, optional
+	ClientCert           string `json:"clientCert" yaml:"clientCert"` // identity of the client certificate, optional
+
+	// What spending returns when 3s-rules tests the sample, optional. The
+	// service reads the recorded transactions instead.
+	Spending *SampleSpending `json:"spending" yaml:"spending"`
+}
+
+// SampleSpending is the result of spending for a sample, whatever the filter
+type SampleSpending struct {
+	Value string `json:"value" yaml:"value"` // in wei, 0 when left out
+	Gas   uint64 `json:"gas" yaml:"gas"`
+	Count uint64 `json:"count" yaml:"count"`
+}
+
+// LoadSamples reads a YAML or JSON array of samples
+func LoadSamples(r io.Reader) ([]Sample, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var samples []Sample
+	err = yaml.UnmarshalStrict(b, &samples)
+	return samples, err
+}
+
+// Transaction builds the unsigned transaction of a sample with ParseFees and
+// NewTransaction, like the server. Without a node to ask, the gas defaults to
+// 21000, a sample without fees is a legacy transaction with a gas price of 0,
+// and a missing fee cap is the tip, or 0.
+func (s Sample) Transaction(chainID *big.Int) (*types.Transaction, error) {
+	var to *common.Address
+	if s.To != "" {
+		address := common.HexToAddress(s.To)
+		to = &address
+	}
+	value, err := ParseValue(s.Value)
+	if err != nil {
+		return nil, err
+	}
+	fees, err := ParseFees(s.GasPrice, s.MaxFeePerGas, s.MaxPriorityFeePerGas)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case fees.GasFeeCap == nil && fees.GasTipCap == nil:
+		if fees.GasPrice == nil {
+			fees.GasPrice = new(big.Int)
+		}
+	case fees.GasTipCap == nil:
+		fees.GasTipCap = new(big.Int)
+	case fees.GasFeeCap == nil:
+		fees.GasFeeCap = new(big.Int).Set(fees.GasTipCap)
+	}
+
+	gas := s.Gas
+	if gas == 0 {
+		gas = 21000
+	}
+	return NewTransaction(chainID, s.Nonce, to, value, gas, fees, common.Hex2Bytes(s.Data)), nil
+}
+
+// Check runs the rules against the sample and tells if the result isn't the
+// expected one
+func (s Sample) Check(rules Rules, from common.Address, chainID *big.Int) error {
+	tx, err := s.Transaction(chainID)
+	if err != nil {
+		return err
+	}
+	d, err := rules.Validate(tx, from, chainID, Caller{ID: s.Caller, ClientCert: s.ClientCert})
+	if err != nil {
+		return err
+	}
+	if d.Allowed != s.Valid {
+		return fmt.Errorf("validate = %v, want %v (%v %v)", d.Allowed, s.Valid, d.Code, d.Message)
+	}
+	if s.Code != "" && d.Code != s.Code {
+		return fmt.Errorf("code = %v, want %v", d.Code, s.Code)
+	}
+	return nil
+}
+
+// CheckSamples tells if the rules accept and refuse the samples as expected
+func CheckSamples(rules Rules, samples []Sample, from common.Address, chainID *big.Int) error {
+	for i, s := range samples {
+		err := s.Check(rules, from, chainID)
+		if err != nil {
+			if s.Name != "" {
+				return fmt.Errorf("sample %v: %v", s.Name, err)
+			}
+			return fmt.Errorf("sample %v: %v", i, err)
+		}
+	}
+	return nil
+}
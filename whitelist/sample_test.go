@@ -0,0 +1,101 @@
+package whitelist
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func Test_samples(t *testing.T) {
+	rules := newTestValidator(t, `
+function validate(tx)
+	if tx.to ~= "0x5597285BbE81BaF351e2C0884e9a5f4416958862" then
+		return false, {code = "receiver", message = "unknown receiver"}
+	end
+	return true
+end`)
+
+	t.Run("Can load YAML samples", func(t *testing.T) {
+		samples, err := LoadSamples(strings.NewReader(`
+- name: payment
+  to: "0x5597285BbE81BaF351e2C0884e9a5f4416958862"
+  value: "1"
+  maxFeePerGas: "2000000000"
+  maxPriorityFeePerGas: "1000000000"
+  valid: true
+- to: "0xC7f965a58942dbf4E9fbdf77A511863d7041339d"
+  valid: false
+  code: receiver
+  spending:
+    value: "5"
+    count: 1
+`))
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if len(samples) != 2 || samples[0].Name != "payment" || samples[0].Spending != nil || samples[1].Spending.Value != "5" {
+			t.Fatalf("samples = %+v", samples)
+			return
+		}
+
+		tx, err := samples[0].Transaction(big.NewInt(1337))
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if tx.Type() != types.DynamicFeeTxType || tx.Gas() != 21000 || tx.GasFeeCap().String() != "2000000000" {
+			t.Errorf("transaction = %+v", tx)
+		}
+
+		err = CheckSamples(rules, samples, common.Address{}, big.NewInt(1337))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("Rejects unknown fields", func(t *testing.T) {
+		_, err := LoadSamples(strings.NewReader(`[{"to": "0x5597285BbE81BaF351e2C0884e9a5f4416958862", "vaild": true}]`))
+		if err == nil {
+			t.Errorf("error = %v, want an error", err)
+		}
+	})
+
+	t.Run("Reports a mismatch", func(t *testing.T) {
+		for _, s := range []Sample{
+			{Name: "refused", To: "0xC7f965a58942dbf4E9fbdf77A511863d7041339d", Valid: true},
+			{Name: "wrong code", To: "0xC7f965a58942dbf4E9fbdf77A511863d7041339d", Valid: false, Code: "limit"},
+		} {
+			err := CheckSamples(rules, []Sample{s}, common.Address{}, big.NewInt(1))
+			if err == nil || !strings.HasPrefix(err.Error(), "sample "+s.Name) {
+				t.Errorf("%v: error = %v, want a mismatch", s.Name, err)
+			}
+		}
+	})
+}
+
+func Test_sampleTransaction(t *testing.T) {
+	t.Run("Refuses the fees the server refuses", func(t *testing.T) {
+		for _, s := range []Sample{
+			{GasPrice: "1", MaxFeePerGas: "2"},
+			{MaxFeePerGas: "1", MaxPriorityFeePerGas: "2"},
+			{Value: "1e18"},
+		} {
+			if _, err := s.Transaction(big.NewInt(1)); err == nil {
+				t.Errorf("%+v: error = %v, want an error", s, err)
+			}
+		}
+
+		tx, err := Sample{MaxPriorityFeePerGas: "2"}.Transaction(big.NewInt(1))
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if tx.GasFeeCap().String() != "2" || tx.GasTipCap().String() != "2" {
+			t.Errorf("fee caps = %v %v, want %v %v", tx.GasFeeCap(), tx.GasTipCap(), 2, 2)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package whitelist
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Fees are the gas price of a legacy transaction, or the fee caps of a
+// dynamic fee transaction when GasPrice is nil
+type Fees struct {
+	GasPrice  *big.Int
+	GasFeeCap *big.Int // maxFeePerGas
+	GasTipCap *big.Int // maxPriorityFeePerGas
+}
+
+// Dynamic tells if the fees are for an EIP-1559 transaction
+func (f Fees) Dynamic() bool {
+	return f.GasPrice == nil
+}
+
+// ParseFees reads the fees of a payload or a sample, as decimal strings. The
+// missing ones are nil.
+func ParseFees(gasPrice, maxFeePerGas, maxPriorityFeePerGas string) (Fees, error) {
+	var f Fees
+	var ok bool
+	if gasPrice != "" {
+		f.GasPrice, ok = big.NewInt(0).SetString(gasPrice, 10)
+		if !ok {
+			return f, errors.New("couldn't convert gasPrice to big.Int")
+		}
+	}
+	if maxFeePerGas != "" {
+		f.GasFeeCap, ok = big.NewInt(0).SetString(maxFeePerGas, 10)
+		if !ok {
+			return f, errors.New("couldn't convert maxFeePerGas to big.Int")
+		}
+	}
+	if maxPriorityFeePerGas != "" {
+		f.GasTipCap, ok = big.NewInt(0).SetString(maxPriorityFeePerGas, 10)
+		if !ok {
+			return f, errors.New("couldn't convert maxPriorityFeePerGas to big.Int")
+		}
+	}
+	if f.GasPrice != nil && (f.GasFeeCap != nil || f.GasTipCap != nil) {
+		return f, errors.New("gasPrice can't be combined with maxFeePerGas or maxPriorityFeePerGas")
+	}
+	if f.GasFeeCap != nil && f.GasTipCap != nil && f.GasTipCap.Cmp(f.GasFeeCap) > 0 {
+		return f, errors.New("maxPriorityFeePerGas can't be higher than maxFeePerGas")
+	}
+	return f, nil
+}
+
+// ParseValue reads an amount in wei given as a decimal string, 0 when empty
+func ParseValue(value string) (*big.Int, error) {
+	if value == "" {
+		return new(big.Int), nil
+	}
+	i, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return nil, errors.New("couldn't convert value to big.Int")
+	}
+	return i, nil
+}
+
+// NewTransaction builds an unsigned legacy or dynamic fee transaction, the
+// way the server and the samples do. A nil destination creates a contract.
+func NewTransaction(
+	chainID *big.Int,
+	nonce uint64,
+	to *common.Address,
+	value *big.Int,
+	gas uint64,
+	fees Fees,
+	data []byte,
+) *types.Transaction {
+	if !fees.Dynamic() {
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    nonce,
+			To:       to,
+			Value:    value,
+			Gas:      gas,
+			GasPrice: fees.GasPrice,
+			Data:     data,
+		})
+	}
+	return types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     nonce,
+		To:        to,
+		Value:     value,
+		Gas:       gas,
+		GasFeeCap: fees.GasFeeCap,
+		GasTipCap: fees.GasTipCap,
+		Data:      data,
+	})
+}
@@ -0,0 +1,359 @@
+package whitelist
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// txToLTable exposes a transaction to the rules. Amounts are decimal strings.
+// valueNumber is the value as a Lua number, rounded above 2^53 wei: exact
+// comparisons need bigcmp.
+func txToLTable(L *lua.LState, tx *types.Transaction, from common.Address, chainID *big.Int, abis ABIRegistry) *lua.LTable {
+	t := L.NewTable()
+	L.SetField(t, "from", lua.LString(from.String()))
+	if tx.To() != nil {
+		L.SetField(t, "to", lua.LString(tx.To().String()))
+	}
+	L.SetField(t, "contractCreation", lua.LBool(tx.To() == nil))
+	if tx.Value() != nil {
+		L.SetField(t, "value", lua.LString(tx.Value().String()))
+		value, _ := new(big.Float).SetInt(tx.Value()).Float64()
+		L.SetField(t, "valueNumber", lua.LNumber(value))
+	}
+	if tx.Data() != nil {
+		L.SetField(t, "data", lua.LString(common.Bytes2Hex((tx.Data()))))
+	}
+	if len(tx.Data()) >= 4 {
+		L.SetField(t, "selector", lua.LString(common.Bytes2Hex(tx.Data()[:4])))
+	}
+	L.SetField(t, "nonce", lua.LNumber(tx.Nonce()))
+	L.SetField(t, "gas", lua.LNumber(tx.Gas()))
+	L.SetField(t, "type", lua.LNumber(tx.Type()))
+	if tx.Type() == types.LegacyTxType {
+		L.SetField(t, "gasPrice", lua.LString(tx.GasPrice().String()))
+	} else {
+		L.SetField(t, "maxFeePerGas", lua.LString(tx.GasFeeCap().String()))
+		L.SetField(t, "maxPriorityFeePerGas", lua.LString(tx.GasTipCap().String()))
+	}
+	if chainID != nil {
+		L.SetField(t, "chainId", lua.LNumber(chainID.Uint64()))
+	}
+	if method, args := abis.decode(tx); method != nil {
+		L.SetField(t, "method", lua.LString(method.RawName))
+		L.SetField(t, "args", argsToLTable(L, method.Inputs, args))
+	}
+	return t
+}
+
+// callerToLTable exposes who asked for the transaction, as the second
+// argument of validate. Unknown fields are nil.
+func callerToLTable(L *lua.LState, caller Caller) *lua.LTable {
+	t := L.NewTable()
+	if caller.ID != "" {
+		L.SetField(t, "id", lua.LString(caller.ID))
+	}
+	if caller.ClientCert != "" {
+		L.SetField(t, "clientCert", lua.LString(caller.ClientCert))
+	}
+	return t
+}
+
+const (
+	// PoolSize is the number of Lua states prepared in advance
+	PoolSize = 16
+	// DefaultTimeout bounds a run of the rules when RULES_TIMEOUT isn't set
+	DefaultTimeout = time.Second
+
+	rulesCallStackSize   = 64
+	rulesRegistrySize    = 1024 * 16
+	rulesRegistryMaxSize = 1024 * 256
+	rulesMaxRepSize      = 1024 * 1024 // bytes string.rep may return
+)
+
+// ErrTimeout is returned when the rules run longer than their timeout
+var ErrTimeout = errors.New("the rules timed out")
+
+// Validator runs the Lua rules. The script is compiled once, and every call
+// gets a Lua state of its own, prepared in advance, so no globals leak from
+// one transaction to the next.
+type Validator struct {
+	proto   *lua.FunctionProto
+	abis    ABIRegistry
+	db      SpendingReader
+	timeout time.Duration
+	states  chan *lua.LState
+}
+
+// NewValidator compiles the rules and fills a pool of poolSize states. The
+// calls to the contracts of abis are decoded, and db answers the spending
+// queries. Every run of the script, at load or validation, is stopped after
+// timeout.
+func NewValidator(rules string, abis ABIRegistry, db SpendingReader, poolSize int, timeout time.Duration) (*Validator, error) {
+	chunk, err := parse.Parse(strings.NewReader(rules), "<string>")
+	if err != nil {
+		return nil, err
+	}
+	proto, err := lua.Compile(chunk, "<string>")
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Validator{
+		proto:   proto,
+		abis:    abis,
+		db:      db,
+		timeout: timeout,
+		states:  make(chan *lua.LState, poolSize),
+	}
+	for i := 0; i < poolSize; i++ {
+		L, err := v.newState()
+		if err != nil {
+			return nil, err
+		}
+		v.states <- L
+	}
+	return v, nil
+}
+
+// newState runs the compiled script in a new, sandboxed Lua state. Only the
+// base, table, string and math libraries are available, without the functions
+// loading code, and string.rep is capped. Other allocations are only bounded
+// by the timeout.
+func (v *Validator) newState() (*lua.LState, error) {
+	L := lua.NewState(lua.Options{
+		CallStackSize:   rulesCallStackSize,
+		RegistrySize:    rulesRegistrySize,
+		RegistryMaxSize: rulesRegistryMaxSize,
+		SkipOpenLibs:    true,
+	})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	if str, ok := L.GetGlobal(lua.StringLibName).(*lua.LTable); ok {
+		str.RawSetString("rep", L.NewFunction(strRep))
+	}
+	L.SetGlobal("bigcmp", L.NewFunction(bigCmp))
+	L.SetGlobal("bigadd", L.NewFunction(bigAdd))
+	L.SetGlobal("spending", L.NewFunction(v.luaSpending))
+
+	ctx, cancel := context.WithTimeout(context.Background(), v.timeout)
+	defer cancel()
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	L.Push(L.NewFunctionFromProto(v.proto))
+	err := L.PCall(0, lua.MultRet, nil)
+	if err != nil {
+		L.Close()
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ErrTimeout
+		}
+		return nil, err
+	}
+	return L, nil
+}
+
+// refill replaces a state taken from the pool
+func (v *Validator) refill() {
+	L, err := v.newState()
+	if err != nil {
+		return
+	}
+	select {
+	case v.states <- L:
+	default:
+		L.Close()
+	}
+}
+
+// Validate runs the rules against an unsigned transaction sent by from
+func (v *Validator) Validate(tx *types.Transaction, from common.Address, chainID *big.Int, caller Caller) (Decision, error) {
+	var L *lua.LState
+	select {
+	case L = <-v.states:
+		go v.refill()
+	default:
+		var err error
+		L, err = v.newState()
+		if err != nil {
+			return Decision{}, err
+		}
+	}
+	defer L.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), v.timeout)
+	defer cancel()
+	L.SetContext(ctx)
+
+	err := L.CallByParam(
+		lua.P{
+			Fn:      L.GetGlobal("validate"),
+			NRet:    2,
+			Protect: true,
+		},
+		txToLTable(L, tx, from, chainID, v.abis),
+		callerToLTable(L, caller),
+	)
+	if ctx.Err() == context.DeadlineExceeded {
+		return Decision{}, ErrTimeout
+	}
+	if err != nil {
+		return Decision{}, err
+	}
+
+	d := luaToDecision(L.Get(-2), L.Get(-1))
+	L.Pop(2)
+
+	return d, nil
+}
+
+// luaToDecision reads what validate returned: a boolean, optionally
+// followed by a reason message or a table with code and message, or a table
+// with allow, code and message.
+func luaToDecision(ret, reason lua.LValue) Decision {
+	var d Decision
+	if t, ok := ret.(*lua.LTable); ok {
+		d.Allowed = t.RawGetString("allow") == lua.LTrue
+		reason = t
+	} else {
+		d.Allowed = ret == lua.LTrue
+	}
+
+	switch r := reason.(type) {
+	case lua.LString:
+		d.Message = string(r)
+	case *lua.LTable:
+		d.Code = lua.LVAsString(r.RawGetString("code"))
+		d.Message = lua.LVAsString(r.RawGetString("message"))
+	}
+	return d
+}
+
+// validate compiles the rules and runs them once
+func validate(rules string, abis ABIRegistry, tx *types.Transaction, from common.Address, chainID *big.Int) (bool, error) {
+	v, err := NewValidator(rules, abis, nil, 0, DefaultTimeout)
+	if err != nil {
+		return false, err
+	}
+	d, err := v.Validate(tx, from, chainID, Caller{})
+	return d.Allowed, err
+}
+
+// luaSpending sums up the transactions recorded in the last window seconds,
+// optionally only those of a signing account, of a caller, sent to an address
+// or creating contracts:
+// spending{window = 3600, from = tx.from, apiKey = caller.id, to = "0x...", contractCreation = false}
+func (v *Validator) luaSpending(L *lua.LState) int {
+	opts := L.CheckTable(1)
+	window, ok := opts.RawGetString("window").(lua.LNumber)
+	if !ok || window <= 0 {
+		L.ArgError(1, "window must be a positive number of seconds")
+	}
+	if v.db == nil {
+		L.RaiseError("spending isn't available")
+	}
+
+	f := SpendingFilter{
+		Since:            time.Now().Add(-time.Duration(float64(window) * float64(time.Second))),
+		ContractCreation: opts.RawGetString("contractCreation") == lua.LTrue,
+	}
+	if from, ok := opts.RawGetString("from").(lua.LString); ok {
+		f.From = string(from)
+	}
+	if to, ok := opts.RawGetString("to").(lua.LString); ok {
+		f.To = string(to)
+	}
+	if apiKey, ok := opts.RawGetString("apiKey").(lua.LString); ok {
+		f.APIKey = string(apiKey)
+	}
+
+	s, err := v.db.Spending(f)
+	if err != nil {
+		L.RaiseError("error reading the spending: %v", err)
+	}
+
+	t := L.NewTable()
+	L.SetField(t, "value", lua.LString(s.Value.String()))
+	value, _ := new(big.Float).SetInt(s.Value).Float64()
+	L.SetField(t, "valueNumber", lua.LNumber(value))
+	L.SetField(t, "gas", lua.LNumber(s.Gas))
+	L.SetField(t, "count", lua.LNumber(s.Count))
+	L.Push(t)
+	return 1
+}
+
+// strRep is string.rep refusing to build a string of more than
+// rulesMaxRepSize bytes in one call
+func strRep(L *lua.LState) int {
+	str := L.CheckString(1)
+	n := L.CheckInt(2)
+	if n <= 0 || len(str) == 0 {
+		L.Push(lua.LString(""))
+		return 1
+	}
+	if n > rulesMaxRepSize/len(str) {
+		L.RaiseError("string.rep: result larger than %v bytes", rulesMaxRepSize)
+	}
+	L.Push(lua.LString(strings.Repeat(str, n)))
+	return 1
+}
+
+// bigCmp compares two integers exactly, given as decimal strings or numbers,
+// and returns -1, 0 or 1. Lua numbers can't hold most token amounts.
+func bigCmp(L *lua.LState) int {
+	a := checkBigInt(L, 1)
+	b := checkBigInt(L, 2)
+	L.Push(lua.LNumber(a.Cmp(b)))
+	return 1
+}
+
+// bigAdd adds two integers exactly, given as decimal strings or numbers, and
+// returns the sum as a decimal string
+func bigAdd(L *lua.LState) int {
+	a := checkBigInt(L, 1)
+	b := checkBigInt(L, 2)
+	L.Push(lua.LString(new(big.Int).Add(a, b).String()))
+	return 1
+}
+
+func checkBigInt(L *lua.LState, n int) *big.Int {
+	switch v := L.Get(n).(type) {
+	case lua.LNumber:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			L.ArgError(n, "not a finite number")
+		}
+		i, _ := big.NewFloat(float64(v)).Int(nil)
+		return i
+	case lua.LString:
+		i, ok := new(big.Int).SetString(string(v), 10)
+		if !ok {
+			L.ArgError(n, "not an integer")
+		}
+		return i
+	default:
+		L.ArgError(n, "integer expected")
+		return nil
+	}
+}
@@ -0,0 +1,369 @@
+package whitelist
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func newTestValidator(t *testing.T, rules string) *Validator {
+	v, err := NewValidator(rules, nil, nil, 1, DefaultTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func Test_validate(t *testing.T) {
+	t.Run("Can validate a transaction", func(t *testing.T) {
+		tx := types.NewTransaction(
+			1,
+			common.HexToAddress("0x5597285BbE81BaF351e2C0884e9a5f4416958862"),
+			big.NewInt(1),
+			21000,
+			big.NewInt(10000000000),
+			[]byte("abcd"),
+		)
+
+		rules := `
+function validate(tx)
+	return tx.to == "0x5597285BbE81BaF351e2C0884e9a5f4416958862" or tx.value == "10000000000"
+end
+`
+		got, _ := validate(rules, nil, tx, common.Address{}, nil)
+		want := true
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("validate = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Can invalidate a transaction", func(t *testing.T) {
+		tx := types.NewTransaction(
+			1,
+			common.HexToAddress("0x5597285BbE81BaF351e2C0884e9a5f4416958861"),
+			big.NewInt(1),
+			21000,
+			big.NewInt(10000000000),
+			[]byte("abcd"),
+		)
+
+		rules := `
+function validate(tx)
+	return tx.to == "0x5597285BbE81BaF351e2C0884e9a5f4416958862" or tx.value == "10000000000"
+end
+`
+		got, _ := validate(rules, nil, tx, common.Address{}, nil)
+		want := false
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("validate = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Can filter on data field", func(t *testing.T) {
+		tx := types.NewContractCreation(
+			1,
+			big.NewInt(0),
+			21000,
+			big.NewInt(10000000000),
+			common.Hex2Bytes("608060405234801561001057600080fd5b5060408051808201909152600c8082527f68656c6c6f20776f726c6421000000000000000000000000000000000000000060209092019182526100559160009161005b565b506100f6565b828054600181600116156101000203166002900490600052602060002090601f016020900481019282601f1061009c57805160ff19168380011785556100c9565b828001600101855582156100c9579182015b828111156100c95782518255916020019190600101906100ae565b506100d59291506100d9565b5090565b6100f391905b808211156100d557600081556001016100df565b90565b610365806101056000396000f3fe608060405234801561001057600080fd5b50600436106100365760003560e01c8063368b87721461003b578063e21f37ce146100e3575b600080fd5b6100e16004803603602081101561005157600080fd5b81019060208101813564010000000081111561006c57600080fd5b82018360208201111561007e57600080fd5b803590602001918460018302840111640100000000831117156100a057600080fd5b91908080601f016020809104026020016040519081016040528093929190818152602001838380828437600092019190915250929550610160945050505050565b005b6100eb610210565b6040805160208082528351818301528351919283929083019185019080838360005b8381101561012557818101518382015260200161010d565b50505050905090810190601f1680156101525780820380516001836020036101000a031916815260200191505b509250505060405180910390f35b805161017390600090602084019061029e565b50604080516020808252835181830152835133937f2ef3fc8a662077a0e040f48a65ffa7573c31f49d3f910d11faaebafb4024c6529386939092839283019185019080838360005b838110156101d35781810151838201526020016101bb565b50505050905090810190601f1680156102005780820380516001836020036101000a031916815260200191505b509250505060405180910390a250565b6000805460408051602060026001851615610100026000190190941693909304601f810184900484028201840190925281815292918301828280156102965780601f1061026b57610100808354040283529160200191610296565b820191906000526020600020905b81548152906001019060200180831161027957829003601f168201915b505050505081565b828054600181600116156101000203166002900490600052602060002090601f016020900481019282601f106102df57805160ff191683800117855561030c565b8280016001018555821561030c579182015b8281111561030c5782518255916020019190600101906102f1565b5061031892915061031c565b5090565b61033691905b808211156103185760008155600101610322565b9056fea165627a7a72305820a9ef249bf31d8f35838cabe1e58664dd84b47baf7830cda567c5e7ae48ee5b320029"),
+		)
+
+		rules := `
+		bytecode = "608060405234801561001057600080fd5b5060408051808201909152600c8082527f68656c6c6f20776f726c6421000000000000000000000000000000000000000060209092019182526100559160009161005b565b506100f6565b828054600181600116156101000203166002900490600052602060002090601f016020900481019282601f1061009c57805160ff19168380011785556100c9565b828001600101855582156100c9579182015b828111156100c95782518255916020019190600101906100ae565b506100d59291506100d9565b5090565b6100f391905b808211156100d557600081556001016100df565b90565b610365806101056000396000f3fe608060405234801561001057600080fd5b50600436106100365760003560e01c8063368b87721461003b578063e21f37ce146100e3575b600080fd5b6100e16004803603602081101561005157600080fd5b81019060208101813564010000000081111561006c57600080fd5b82018360208201111561007e57600080fd5b803590602001918460018302840111640100000000831117156100a057600080fd5b91908080601f016020809104026020016040519081016040528093929190818152602001838380828437600092019190915250929550610160945050505050565b005b6100eb610210565b6040805160208082528351818301528351919283929083019185019080838360005b8381101561012557818101518382015260200161010d565b50505050905090810190601f1680156101525780820380516001836020036101000a031916815260200191505b509250505060405180910390f35b805161017390600090602084019061029e565b50604080516020808252835181830152835133937f2ef3fc8a662077a0e040f48a65ffa7573c31f49d3f910d11faaebafb4024c6529386939092839283019185019080838360005b838110156101d35781810151838201526020016101bb565b50505050905090810190601f1680156102005780820380516001836020036101000a031916815260200191505b509250505060405180910390a250565b6000805460408051602060026001851615610100026000190190941693909304601f810184900484028201840190925281815292918301828280156102965780601f1061026b57610100808354040283529160200191610296565b820191906000526020600020905b81548152906001019060200180831161027957829003601f168201915b505050505081565b828054600181600116156101000203166002900490600052602060002090601f016020900481019282601f106102df57805160ff191683800117855561030c565b8280016001018555821561030c579182015b8281111561030c5782518255916020019190600101906102f1565b5061031892915061031c565b5090565b61033691905b808211156103185760008155600101610322565b9056fea165627a7a72305820a9ef249bf31d8f35838cabe1e58664dd84b47baf7830cda567c5e7ae48ee5b320029"
+
+		function string.starts(String,Start)
+			 return string.sub(String,1,string.len(Start))==Start
+		end
+
+		function validate(tx)
+			return tx.to == nil and tx.value == "0" and string.starts(tx.data, bytecode)
+		end
+`
+		got, _ := validate(rules, nil, tx, common.Address{}, nil)
+		want := true
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("validate = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Exposes the transaction fields", func(t *testing.T) {
+		tx := types.NewTx(&types.DynamicFeeTx{
+			ChainID:   big.NewInt(1337),
+			Nonce:     7,
+			To:        nil,
+			Value:     big.NewInt(2000000000000000000),
+			Gas:       50000,
+			GasFeeCap: big.NewInt(30000000000),
+			GasTipCap: big.NewInt(2000000000),
+			Data:      common.Hex2Bytes("a9059cbb00000000"),
+		})
+
+		rules := `
+function validate(tx)
+	return tx.from == "0x5597285BbE81BaF351e2C0884e9a5f4416958862"
+		and tx.to == nil
+		and tx.contractCreation
+		and tx.nonce == 7
+		and tx.gas == 50000
+		and tx.type == 2
+		and tx.gasPrice == nil
+		and tx.maxFeePerGas == "30000000000"
+		and tx.maxPriorityFeePerGas == "2000000000"
+		and tx.chainId == 1337
+		and tx.selector == "a9059cbb"
+		and tx.valueNumber > 1e18 and tx.valueNumber <= 2e18
+end
+`
+		got, err := validate(rules, nil, tx, common.HexToAddress("0x5597285BbE81BaF351e2C0884e9a5f4416958862"), big.NewInt(1337))
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if got != true {
+			t.Errorf("validate = %v, want %v", got, true)
+		}
+	})
+
+	t.Run("Doesn't leak globals between calls", func(t *testing.T) {
+		tx := types.NewTransaction(1, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil)
+
+		v, err := NewValidator(`
+calls = 0
+
+function validate(tx)
+	calls = calls + 1
+	seen = (seen or 0) + 1
+	return calls == 1 and seen == 1
+end
+`, nil, nil, 2, DefaultTimeout)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+
+		for i := 0; i < 5; i++ {
+			got, err := v.Validate(tx, common.Address{}, nil, Caller{})
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			if got.Allowed != true {
+				t.Errorf("call %v: validate = %v, want %v", i, got.Allowed, true)
+			}
+		}
+	})
+
+	t.Run("Exposes the caller", func(t *testing.T) {
+		tx := types.NewTransaction(1, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil)
+		v := newTestValidator(t, `
+function validate(tx, caller)
+	return caller.id == "mobile" and caller.clientCert == "spiffe://example.com/mobile"
+end
+`)
+
+		for _, c := range []struct {
+			caller Caller
+			want   bool
+		}{
+			{Caller{ID: "mobile", ClientCert: "spiffe://example.com/mobile"}, true},
+			{Caller{ID: "mobile"}, false},
+			{Caller{}, false},
+		} {
+			got, err := v.Validate(tx, common.Address{}, nil, c.caller)
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			if got.Allowed != c.want {
+				t.Errorf("%+v: validate = %v, want %v", c.caller, got.Allowed, c.want)
+			}
+		}
+	})
+
+	t.Run("Compares and adds amounts exactly", func(t *testing.T) {
+		// 2^53 + 1 wei, which valueNumber rounds to 2^53
+		tx := types.NewTransaction(1, common.Address{}, big.NewInt(9007199254740993), 21000, big.NewInt(1), nil)
+
+		rules := `
+function validate(tx)
+	return tx.valueNumber == 9007199254740992
+		and bigcmp(tx.value, "9007199254740992") > 0
+		and bigcmp(tx.value, "9007199254740993") == 0
+		and bigadd(tx.value, "1000000000000000000000") == "1000009007199254740993"
+end
+`
+		got, err := validate(rules, nil, tx, common.Address{}, nil)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if got != true {
+			t.Errorf("validate = %v, want %v", got, true)
+		}
+	})
+
+	t.Run("Returns the reason of a refusal", func(t *testing.T) {
+		tx := types.NewTransaction(1, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil)
+
+		for _, c := range []struct {
+			rules string
+			want  Decision
+		}{
+			{`function validate(tx) return true end`, Decision{Allowed: true}},
+			{`function validate(tx) return false end`, Decision{}},
+			{`function validate(tx) return false, "too much" end`, Decision{Message: "too much"}},
+			{`function validate(tx) return false, {code = "limit", message = "too much"} end`, Decision{Code: "limit", Message: "too much"}},
+			{`function validate(tx) return {allow = false, code = "limit", message = "too much"} end`, Decision{Code: "limit", Message: "too much"}},
+			{`function validate(tx) return {allow = true} end`, Decision{Allowed: true}},
+		} {
+			v, err := NewValidator(c.rules, nil, nil, 0, DefaultTimeout)
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			got, err := v.Validate(tx, common.Address{}, nil, Caller{})
+			if err != nil {
+				t.Fatal(err)
+				return
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("%v: decision = %+v, want %+v", c.rules, got, c.want)
+			}
+		}
+	})
+}
+
+func Test_validateLimits(t *testing.T) {
+	t.Run("Fails to compile invalid rules", func(t *testing.T) {
+		_, err := NewValidator(`function validate(tx) return`, nil, nil, 1, DefaultTimeout)
+		if err == nil {
+			t.Errorf("NewValidator error = %v, want an error", err)
+		}
+	})
+
+	t.Run("Times out", func(t *testing.T) {
+		tx := types.NewTransaction(1, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil)
+
+		for _, rules := range []string{
+			`function validate(tx) while true do end end`,
+			`while true do end`,
+		} {
+			v, err := NewValidator(rules, nil, nil, 0, 50*time.Millisecond)
+			if err != nil {
+				if err != ErrTimeout {
+					t.Errorf("NewValidator error = %v, want %v", err, ErrTimeout)
+				}
+				continue
+			}
+			_, err = v.Validate(tx, common.Address{}, nil, Caller{})
+			if err != ErrTimeout {
+				t.Errorf("validate error = %v, want %v", err, ErrTimeout)
+			}
+		}
+	})
+
+	t.Run("Can't use unsafe libraries", func(t *testing.T) {
+		tx := types.NewTransaction(1, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil)
+
+		rules := `
+function validate(tx)
+	return os == nil and io == nil and load == nil and loadstring == nil and dofile == nil
+		and loadfile == nil and require == nil and string.len("abc") == 3
+end
+`
+		got, err := validate(rules, nil, tx, common.Address{}, nil)
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if got != true {
+			t.Errorf("validate = %v, want %v", got, true)
+		}
+	})
+
+	t.Run("Caps string.rep", func(t *testing.T) {
+		tx := types.NewTransaction(1, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil)
+
+		got, err := validate(`function validate(tx) return string.rep("ab", 3) == "ababab" and ("x"):rep(0) == "" end`, nil, tx, common.Address{}, nil)
+		if err != nil || got != true {
+			t.Errorf("validate = %v, %v, want %v", got, err, true)
+		}
+
+		for _, rules := range []string{
+			`function validate(tx) return #string.rep("x", 1e9) > 0 end`,
+			`function validate(tx) return #("xx"):rep(2^62) > 0 end`,
+		} {
+			if _, err := validate(rules, nil, tx, common.Address{}, nil); err == nil {
+				t.Errorf("%v: error = %v, want an error", rules, err)
+			}
+		}
+	})
+
+	t.Run("Stops a runaway recursion", func(t *testing.T) {
+		tx := types.NewTransaction(1, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil)
+
+		rules := `
+function f(n) return 1 + f(n + 1) end
+function validate(tx) return f(0) > 0 end
+`
+		_, err := validate(rules, nil, tx, common.Address{}, nil)
+		if err == nil {
+			t.Errorf("validate error = %v, want an error", err)
+		}
+	})
+
+	t.Run("Rejects amounts that aren't finite", func(t *testing.T) {
+		tx := types.NewTransaction(1, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil)
+
+		for _, amount := range []string{"0/0", "1/0", "-1/0"} {
+			rules := `function validate(tx) return bigcmp(tx.value, ` + amount + `) > 0 end`
+			_, err := validate(rules, nil, tx, common.Address{}, nil)
+			if err == nil || !strings.Contains(err.Error(), "not a finite number") {
+				t.Errorf("validate %v error = %v, want %v", amount, err, "not a finite number")
+			}
+		}
+	})
+}
+
+func Benchmark_validate(b *testing.B) {
+	tx := types.NewTransaction(
+		1,
+		common.HexToAddress("0x5597285BbE81BaF351e2C0884e9a5f4416958862"),
+		big.NewInt(1),
+		21000,
+		big.NewInt(10000000000),
+		[]byte("abcd"),
+	)
+	rules := `
+allowed = {
+	["0x5597285BbE81BaF351e2C0884e9a5f4416958862"] = true,
+	["0xC7f965a58942dbf4E9fbdf77A511863d7041339d"] = true,
+}
+
+function validate(tx)
+	return allowed[tx.to] and bigcmp(tx.value, "10000000000") <= 0
+end
+`
+
+	b.Run("Parsed per call", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			validate(rules, nil, tx, common.Address{}, nil)
+		}
+	})
+
+	b.Run("Compiled and pooled", func(b *testing.B) {
+		v, err := NewValidator(rules, nil, nil, PoolSize, DefaultTimeout)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			v.Validate(tx, common.Address{}, nil, Caller{})
+		}
+	})
+}